@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/abhishek/p110/internal/store"
+)
+
+// actionRow is one line of `p110 actions` output.
+type actionRow struct {
+	Time     time.Time `json:"time"`
+	Rule     string    `json:"rule"`
+	DeviceIP string    `json:"device_ip"`
+	Action   string    `json:"action"`
+	Reason   string    `json:"reason"`
+	DryRun   bool      `json:"dry_run"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// runActions implements `p110 actions`: lists the automation audit log.
+func runActions(args []string) {
+	fs := flag.NewFlagSet("actions", flag.ExitOnError)
+	deviceKey := fs.String("device", "", "Device name (from config), MAC or IP; empty for all devices")
+	dbPath := fs.String("db", "p110.db", "SQLite database path")
+	days := fs.Int("days", 7, "Number of days to show")
+	configPath := fs.String("config", "", "Config file path (default $P110_CONFIG)")
+	jsonOutput := fs.Bool("json", false, "Output in JSON format")
+	fs.Parse(args)
+
+	db, err := store.Open(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	deviceIP := ""
+	if *deviceKey != "" {
+		cfg := loadConfig(*configPath)
+		deviceIP, _, err = resolveArchivedDevice(db, cfg, *deviceKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	records, err := db.GetActionsSince(deviceIP, time.Now().AddDate(0, 0, -*days))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read actions: %v\n", err)
+		os.Exit(1)
+	}
+
+	rows := make([]actionRow, len(records))
+	for i, r := range records {
+		rows[i] = actionRow{
+			Time:     r.Timestamp.Local(),
+			Rule:     r.Rule,
+			DeviceIP: r.DeviceIP,
+			Action:   r.Action,
+			Reason:   r.Reason,
+			DryRun:   r.DryRun,
+			Error:    r.Error,
+		}
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rows)
+		return
+	}
+
+	fmt.Printf("Automation actions (last %d days)\n", *days)
+	fmt.Println(strings.Repeat("─", 70))
+
+	if len(rows) == 0 {
+		fmt.Println("  No actions recorded")
+		return
+	}
+
+	for _, r := range rows {
+		status := ""
+		switch {
+		case r.DryRun:
+			status = " [dry run]"
+		case r.Error != "":
+			status = " [failed: " + r.Error + "]"
+		}
+		fmt.Printf("  %s  %-15s  %-8s  %s: %s%s\n",
+			r.Time.Format("2006-01-02 15:04"), r.DeviceIP, r.Action, r.Rule, r.Reason, status)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/abhishek/p110/internal/tapo"
+)
+
+// runAuth implements `p110 auth hash`: prints the KLAP auth hash for an
+// account so configs and environments can hold it instead of the password.
+func runAuth(args []string) {
+	if len(args) == 0 || args[0] != "hash" {
+		fmt.Fprintln(os.Stderr, "Usage: p110 auth hash [-username email]")
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("auth hash", flag.ExitOnError)
+	username := fs.String("username", os.Getenv("TAPO_USERNAME"), "Tapo account username (email)")
+	fs.Parse(args[1:])
+
+	in := bufio.NewReader(os.Stdin)
+	if *username == "" {
+		*username = prompt(in, "Username: ", false)
+	}
+	password := os.Getenv("TAPO_PASSWORD")
+	if password == "" {
+		password = prompt(in, "Password: ", true)
+	}
+	if *username == "" || password == "" {
+		fmt.Fprintln(os.Stderr, "Error: username and password required")
+		os.Exit(1)
+	}
+
+	fmt.Println(tapo.AuthHash(*username, password))
+	fmt.Fprintln(os.Stderr, `Use it as "auth_hash" in the config file or in TAPO_AUTH_HASH.`)
+	fmt.Fprintln(os.Stderr, "Anyone holding it can control your devices, so keep it as private as the password.")
+}
+
+// prompt reads a line from the terminal, without echo if secret is set, or
+// from stdin when it isn't a terminal.
+func prompt(in *bufio.Reader, label string, secret bool) string {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, _ := in.ReadString('\n')
+		return strings.TrimRight(line, "\r\n")
+	}
+
+	fmt.Fprint(os.Stderr, label)
+	if secret {
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return ""
+		}
+		return string(b)
+	}
+	line, _ := in.ReadString('\n')
+	return strings.TrimRight(line, "\r\n")
+}
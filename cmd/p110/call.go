@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/abhishek/p110/internal/tapo"
+)
+
+// runCall implements `p110 call`: sends any method to a device and prints the
+// decrypted result, for trying methods the library doesn't wrap.
+func runCall(args []string) {
+	fs := flag.NewFlagSet("call", flag.ExitOnError)
+	conn := addConnFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: p110 call [flags] <method> [json-params]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	method := fs.Arg(0)
+
+	var params interface{}
+	if fs.NArg() == 2 {
+		var raw json.RawMessage
+		if err := json.Unmarshal([]byte(fs.Arg(1)), &raw); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid JSON params: %v\n", err)
+			os.Exit(1)
+		}
+		params = raw
+	}
+
+	client := conn.client()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	deviceIPs := conn.deviceIPs(ctx)
+	cancel()
+
+	failed := false
+	for _, ip := range deviceIPs {
+		if len(deviceIPs) > 1 {
+			fmt.Printf("# %s\n", ip)
+		}
+
+		device, err := client.Connect(ip)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] Connection failed: %v\n", ip, err)
+			failed = true
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		var result json.RawMessage
+		err = device.Call(ctx, method, params, &result)
+		cancel()
+
+		var devErr *tapo.DeviceError
+		switch {
+		case errors.As(err, &devErr):
+			fmt.Fprintf(os.Stderr, "[%s] %s: error_code %d\n", ip, method, devErr.Code)
+			failed = true
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "[%s] %s failed: %v\n", ip, method, err)
+			failed = true
+		default:
+			var out bytes.Buffer
+			if len(result) == 0 {
+				result = json.RawMessage("null")
+			}
+			if err := json.Indent(&out, result, "", "  "); err != nil {
+				out.Reset()
+				out.Write(result)
+			}
+			fmt.Println(out.String())
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abhishek/p110/internal/cache"
+	"github.com/abhishek/p110/internal/config"
+	"github.com/abhishek/p110/internal/store"
+	"github.com/abhishek/p110/internal/tapo"
+)
+
+// commands maps subcommand names to their entry points. Anything else on the
+// command line is handled by the flag-driven mode in main.
+var commands = map[string]func(args []string){
+	"watch":    runWatch,
+	"forecast": runForecast,
+	"cycles":   runCycles,
+	"actions":  runActions,
+	"events":   runEvents,
+	"timer":    runTimer,
+	"schedule": runSchedule,
+	"settings": runSettings,
+	"call":     runCall,
+	"firmware": runFirmware,
+	"status":   runStatus,
+	"auth":     runAuth,
+	"login":    runLogin,
+	"logout":   runLogout,
+	"on":       func(args []string) { runControl("on", args) },
+	"off":      func(args []string) { runControl("off", args) },
+	"toggle":   func(args []string) { runControl("toggle", args) },
+}
+
+// loadConfig reads the config file, exiting on error.
+func loadConfig(path string) *config.Config {
+	cfg, err := config.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return cfg
+}
+
+// connFlags holds the connection flags shared by subcommands.
+type connFlags struct {
+	username *string
+	password *string
+	ip       *string
+	all      *bool
+	timeout  *time.Duration
+	iface    *string
+	subnet   *string
+	scan     *string
+	credFile *string
+	cfgPath  *string
+	cache    *string
+	refresh  *bool
+	kasa     *bool
+
+	cfg        *config.Config // loaded on first use
+	devCache   *cache.Cache   // loaded on first use
+	tapoClient *tapo.Client   // set by client
+}
+
+// addConnFlags registers the connection flags on a subcommand's flag set.
+func addConnFlags(fs *flag.FlagSet) *connFlags {
+	return &connFlags{
+		username: fs.String("username", "", "Tapo account username (email)"),
+		password: fs.String("password", "", "Tapo account password"),
+		ip:       fs.String("ip", "", "Device IP address (optional, will auto-discover if not provided)"),
+		all:      fs.Bool("all", false, "Use all cached devices; discover if the cache is empty or stale, or with -refresh"),
+		timeout:  fs.Duration("timeout", 5*time.Second, "Discovery timeout"),
+		iface:    fs.String("iface", "", "Discover only on these network interfaces (comma-separated)"),
+		subnet:   fs.String("subnet", "", "Discover by directed broadcast to these CIDRs (comma-separated)"),
+		scan:     fs.String("scan", "", "Also probe every address in these CIDRs by unicast (comma-separated)"),
+		credFile: fs.String("credentials-file", "", "JSON file with the account credentials (must be mode 0600)"),
+		cfgPath:  fs.String("config", "", "Config file path (default $P110_CONFIG)"),
+		cache:    fs.String("cache", cache.DefaultPath(), "Device cache file"),
+		refresh:  fs.Bool("refresh", false, "Rediscover devices instead of trusting the cache"),
+		kasa:     fs.Bool("kasa", false, "Also discover Kasa devices on port 9999"),
+	}
+}
+
+// config returns the config file named by -config, exiting on error.
+func (c *connFlags) config() *config.Config {
+	if c.cfg == nil {
+		c.cfg = loadConfig(*c.cfgPath)
+	}
+	return c.cfg
+}
+
+// deviceCache returns the device cache named by -cache.
+func (c *connFlags) deviceCache() *cache.Cache {
+	if c.devCache == nil {
+		c.devCache = loadDeviceCache(*c.cache)
+	}
+	return c.devCache
+}
+
+// loadDeviceCache reads the device cache, starting empty if it can't be read.
+func loadDeviceCache(path string) *cache.Cache {
+	devCache, err := cache.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return cache.New(path)
+	}
+	return devCache
+}
+
+// discoverer returns a Discoverer configured by -timeout, -iface, -subnet,
+// -scan and -kasa.
+func (c *connFlags) discoverer() *tapo.Discoverer {
+	return newDiscoverer(*c.timeout, *c.iface, *c.subnet, *c.scan, *c.kasa)
+}
+
+// newDiscoverer builds a Discoverer from comma-separated interface, subnet
+// and scan range lists.
+func newDiscoverer(timeout time.Duration, ifaces, subnets, scan string, kasa bool) *tapo.Discoverer {
+	d := tapo.NewDiscoverer(timeout)
+	d.Interfaces = splitList(ifaces)
+	d.Subnets = splitList(subnets)
+	d.Scan = splitList(scan)
+	d.Kasa = kasa
+	return d
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// client returns a Tapo client for the credentials given (see newClient).
+// It exits if no credentials are found.
+func (c *connFlags) client() *tapo.Client {
+	c.tapoClient = newClient(*c.username, *c.password, *c.credFile, c.config())
+	registerDevices(c.tapoClient, c.config(), c.deviceCache())
+	return c.tapoClient
+}
+
+// deviceIPs resolves the devices selected by -ip/-all. It exits if none are found.
+func (c *connFlags) deviceIPs(ctx context.Context) []string {
+	ips, err := resolveDeviceIPs(ctx, *c.ip, *c.all, c.discoverer(), c.deviceCache(), *c.refresh)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Discovery failed: %v\n", err)
+		os.Exit(1)
+	}
+	if len(ips) == 0 {
+		fmt.Fprintln(os.Stderr, "No devices found")
+		os.Exit(1)
+	}
+	// Discovery may have found new endpoints.
+	if c.tapoClient != nil {
+		registerDevices(c.tapoClient, c.config(), c.deviceCache())
+	}
+	return ips
+}
+
+const (
+	// cacheVerifyTimeout bounds the handshake that confirms a cached address.
+	cacheVerifyTimeout = time.Second
+
+	// cacheMaxAge is how long a device that stops answering stays cached.
+	cacheMaxAge = 7 * 24 * time.Hour
+)
+
+// resolveDeviceIPs returns the explicit IP if given, every device if all is
+// set, or a single device otherwise. Cached devices are used when they
+// answer a handshake; if any doesn't, or refresh is set, devices are
+// discovered and the cache updated.
+func resolveDeviceIPs(ctx context.Context, ip string, all bool, disc *tapo.Discoverer, devCache *cache.Cache, refresh bool) ([]string, error) {
+	if ip != "" {
+		return []string{ip}, nil
+	}
+
+	if !refresh {
+		if ips := cachedDeviceIPs(ctx, devCache, all); len(ips) > 0 {
+			return ips, nil
+		}
+	}
+
+	var devices []tapo.DiscoveredDevice
+	if all {
+		found, err := disc.All(ctx)
+		if err != nil {
+			return nil, err
+		}
+		devices = found
+	} else {
+		device, err := disc.First(ctx)
+		if err != nil {
+			return nil, err
+		}
+		devices = []tapo.DiscoveredDevice{*device}
+	}
+
+	now := time.Now()
+	var ips []string
+	for _, d := range devices {
+		ips = append(ips, d.IP)
+		devCache.Update(cacheEntry(d, now))
+	}
+	if all {
+		devCache.Prune(now.Add(-cacheMaxAge))
+	}
+	if err := devCache.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save device cache: %v\n", err)
+	}
+	return ips, nil
+}
+
+// cachedDeviceIPs returns every cached device (all) or the most recently
+// seen one, provided each still answers (see pingCached). It returns nil if the cache
+// is empty or any device has moved, so the caller falls back to discovery.
+func cachedDeviceIPs(ctx context.Context, devCache *cache.Cache, all bool) []string {
+	entries := append([]cache.Entry(nil), devCache.Devices...)
+	if len(entries) == 0 {
+		return nil
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].SeenAt.After(entries[j].SeenAt) })
+	if !all {
+		entries = entries[:1]
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cacheVerifyTimeout)
+	defer cancel()
+
+	errs := make([]error, len(entries))
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		wg.Add(1)
+		go func(i int, e cache.Entry) {
+			defer wg.Done()
+			errs[i] = pingCached(ctx, e)
+		}(i, e)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	var ips []string
+	for i, e := range entries {
+		if errs[i] != nil {
+			return nil
+		}
+		ips = append(ips, e.IP)
+		e.SeenAt = now
+		devCache.Update(e)
+	}
+	devCache.Save()
+	return ips
+}
+
+// pingCached checks that a cached device still answers at its address: a
+// KLAP handshake, or the legacy probe for Kasa devices that don't speak KLAP.
+func pingCached(ctx context.Context, e cache.Entry) error {
+	if e.Protocol == "XOR" {
+		return tapo.PingKasa(ctx, e.IP)
+	}
+	return tapo.Ping(ctx, e.IP, e.HTTPPort, e.HTTPS)
+}
+
+// cacheEntry records a discovered device in the cache.
+func cacheEntry(d tapo.DiscoveredDevice, seenAt time.Time) cache.Entry {
+	return cache.Entry{
+		IP:       d.IP,
+		MAC:      d.MAC,
+		DeviceID: d.DeviceID,
+		Model:    d.Model,
+		Nickname: d.Alias,
+		SeenAt:   seenAt,
+		Protocol: d.EncryptType,
+		HTTPPort: d.HTTPPort,
+		HTTPS:    d.HTTPS,
+	}
+}
+
+// isChildKey reports whether key is a strip outlet key such as "192.168.1.50/2".
+func isChildKey(key string) bool {
+	ip, position, ok := strings.Cut(key, "/")
+	if !ok || net.ParseIP(ip) == nil {
+		return false
+	}
+	_, err := strconv.Atoi(position)
+	return err == nil
+}
+
+// resolveArchivedDevice maps a device name, MAC or IP to the IP its data is
+// archived under, using the config file and the readings table.
+func resolveArchivedDevice(db *store.Store, cfg *config.Config, key string) (string, *config.Device, error) {
+	dev := cfg.Device(key)
+	if dev != nil && dev.IP != "" {
+		return dev.IP, dev, nil
+	}
+
+	mac := key
+	if dev != nil {
+		mac = dev.MAC
+	}
+	if dev == nil && (net.ParseIP(key) != nil || isChildKey(key)) {
+		return key, nil, nil
+	}
+
+	ip, err := db.GetDeviceIPByMAC(config.NormalizeMAC(mac))
+	if err != nil {
+		return "", nil, err
+	}
+	if ip == "" {
+		return "", nil, fmt.Errorf("unknown device %q (use an IP, a MAC, or a name from the config file)", key)
+	}
+	return ip, dev, nil
+}
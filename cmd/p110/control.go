@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abhishek/p110/internal/cache"
+	"github.com/abhishek/p110/internal/config"
+	"github.com/abhishek/p110/internal/tapo"
+)
+
+// target is one device resolved from a name, MAC, IP, nickname or group.
+type target struct {
+	key      string // what the user asked for
+	ip       string
+	position int // strip outlet, or 0 for the device itself
+}
+
+func (t target) String() string {
+	ip := t.ip
+	if t.position > 0 {
+		ip = tapo.ChildKey(t.ip, t.position)
+	}
+	if t.key == ip {
+		return ip
+	}
+	return fmt.Sprintf("%s (%s)", t.key, ip)
+}
+
+// runControl implements `p110 on|off|toggle <target>...`.
+func runControl(action string, args []string) {
+	fs := flag.NewFlagSet(action, flag.ExitOnError)
+	conn := addConnFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: p110 %s [flags] <name|nickname|ip|mac|group>...\n", action)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	keys := fs.Args()
+	if *conn.ip != "" {
+		keys = append(keys, *conn.ip)
+	}
+	if len(keys) == 0 && !*conn.all {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	cfg := conn.config()
+	client := conn.client()
+
+	if *conn.all {
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		keys = append(keys, conn.deviceIPs(ctx)...)
+		cancel()
+	}
+
+	targets, errs := resolveTargets(client, cfg, conn.deviceCache(), keys, conn.discoverer(), *conn.refresh)
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	if len(targets) == 0 {
+		os.Exit(1)
+	}
+
+	results := make([]error, len(targets))
+	states := make([]bool, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t target) {
+			defer wg.Done()
+			states[i], results[i] = control(client, t, action)
+		}(i, t)
+	}
+	wg.Wait()
+
+	failed := len(errs) > 0
+	for i, t := range targets {
+		if results[i] != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed: %v\n", t, results[i])
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: turned %s\n", t, onOff(states[i]))
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// control switches one target and returns the state it was switched to.
+func control(client *tapo.Client, t target, action string) (bool, error) {
+	device, err := client.Connect(t.ip)
+	if err != nil {
+		return false, err
+	}
+
+	var sw interface {
+		TurnOn() error
+		TurnOff() error
+	} = device
+	var on bool
+
+	if t.position > 0 {
+		children, err := device.Strip().Children()
+		if err != nil {
+			return false, err
+		}
+		var child *tapo.ChildInfo
+		for i := range children {
+			if children[i].Position == t.position {
+				child = &children[i]
+			}
+		}
+		if child == nil {
+			return false, fmt.Errorf("strip has no outlet %d", t.position)
+		}
+		sw, on = device.Strip().Child(child.DeviceID), child.DeviceON
+	} else if action == "toggle" {
+		info, err := device.GetDeviceInfo()
+		if err != nil {
+			return false, err
+		}
+		on = info.DeviceON
+	}
+
+	want := action == "on" || (action == "toggle" && !on)
+	if want {
+		return true, sw.TurnOn()
+	}
+	return false, sw.TurnOff()
+}
+
+// resolveTargets maps keys to devices. Groups expand to their members;
+// config names and IPs resolve directly; MACs and nicknames are looked up in
+// the device cache. Cached addresses are checked first, and the cache is
+// refreshed by discovery when a key is unknown or its device has moved.
+func resolveTargets(client *tapo.Client, cfg *config.Config, devCache *cache.Cache, keys []string, disc *tapo.Discoverer, refresh bool) ([]target, []error) {
+	keys, err := cfg.ExpandGroups(keys)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	var targets []target
+	var pending []string
+	seen := make(map[target]bool)
+	add := func(t target) {
+		dedupe := target{ip: t.ip, position: t.position}
+		if !seen[dedupe] {
+			seen[dedupe] = true
+			targets = append(targets, t)
+		}
+	}
+
+	// lookup resolves a key without discovery, reporting whether it could.
+	// A key found in the cache also returns its entry.
+	lookup := func(key string) (target, *cache.Entry, bool, error) {
+		lookupKey := key
+		if dev := cfg.Device(key); dev != nil {
+			if dev.IP != "" {
+				lookupKey = dev.IP
+			} else if dev.MAC != "" {
+				lookupKey = dev.MAC
+			}
+		}
+		if ip, pos, ok := strings.Cut(lookupKey, "/"); ok && isChildKey(lookupKey) {
+			n, _ := strconv.Atoi(pos)
+			return target{key: key, ip: ip, position: n}, nil, true, nil
+		}
+		if net.ParseIP(lookupKey) != nil {
+			return target{key: key, ip: lookupKey}, nil, true, nil
+		}
+		switch found := devCache.Find(lookupKey); len(found) {
+		case 0:
+			return target{}, nil, false, nil
+		case 1:
+			return target{key: key, ip: found[0].IP}, &found[0], true, nil
+		default:
+			return target{}, nil, true, fmt.Errorf("%q matches %d devices; use an IP or MAC", key, len(found))
+		}
+	}
+
+	type hit struct {
+		key     string
+		target  target
+		entry   *cache.Entry
+		pingErr error
+	}
+	var errs []error
+	var hits []*hit
+	for _, key := range keys {
+		if refresh {
+			pending = append(pending, key)
+			continue
+		}
+		t, entry, ok, err := lookup(key)
+		switch {
+		case err != nil:
+			errs = append(errs, err)
+		case ok:
+			hits = append(hits, &hit{key: key, target: t, entry: entry})
+		default:
+			pending = append(pending, key)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cacheVerifyTimeout)
+	var wg sync.WaitGroup
+	for _, h := range hits {
+		if h.entry == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(h *hit) {
+			defer wg.Done()
+			h.pingErr = pingCached(ctx, *h.entry)
+		}(h)
+	}
+	wg.Wait()
+	cancel()
+
+	for _, h := range hits {
+		if h.pingErr != nil {
+			pending = append(pending, h.key) // moved or gone; rediscover it
+			continue
+		}
+		add(h.target)
+	}
+
+	if len(pending) == 0 {
+		return targets, errs
+	}
+
+	if err := refreshCache(client, cfg, devCache, disc); err != nil {
+		errs = append(errs, err)
+	}
+	for _, key := range pending {
+		t, _, ok, err := lookup(key)
+		switch {
+		case err != nil:
+			errs = append(errs, err)
+		case ok:
+			add(t)
+		default:
+			errs = append(errs, fmt.Errorf("no device matches %q", key))
+		}
+	}
+	return targets, errs
+}
+
+// refreshCache discovers devices, reads each one's nickname and saves the
+// cache. The client learns the discovered endpoints and accounts first, so
+// devices on another port, over HTTPS or on another account can be read.
+func refreshCache(client *tapo.Client, cfg *config.Config, devCache *cache.Cache, disc *tapo.Discoverer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), disc.Timeout+time.Second)
+	defer cancel()
+
+	devices, err := disc.All(ctx)
+	if err != nil && len(devices) == 0 {
+		return fmt.Errorf("discovery failed: %w", err)
+	}
+
+	now := time.Now()
+	entries := make([]cache.Entry, len(devices))
+	for i, d := range devices {
+		entries[i] = cacheEntry(d, now)
+		devCache.Update(entries[i])
+	}
+	registerDevices(client, cfg, devCache)
+
+	var wg sync.WaitGroup
+	for i := range entries {
+		if entries[i].Nickname != "" {
+			continue // Kasa discovery already gave the alias
+		}
+		wg.Add(1)
+		go func(e *cache.Entry) {
+			defer wg.Done()
+			device, err := client.Connect(e.IP)
+			if err != nil {
+				return
+			}
+			if info, err := device.GetDeviceInfo(); err == nil {
+				e.Nickname = info.Nickname
+			}
+		}(&entries[i])
+	}
+	wg.Wait()
+
+	for _, e := range entries {
+		devCache.Update(e)
+	}
+	if err := devCache.Save(); err != nil {
+		return fmt.Errorf("failed to save device cache: %w", err)
+	}
+	return nil
+}
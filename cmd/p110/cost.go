@@ -0,0 +1,237 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/abhishek/p110/internal/config"
+	"github.com/abhishek/p110/internal/store"
+	"github.com/abhishek/p110/internal/tapo"
+	"github.com/abhishek/p110/internal/tariff"
+)
+
+// pricing is the tariff and currency used for cost display. Costs are not
+// shown when tariff is nil.
+type pricing struct {
+	tariff   *tariff.Schedule
+	currency string
+}
+
+// loadPricing picks the tariff for cost display: a flat -rate if given,
+// otherwise the tariffs from the config file. The config currency is used
+// unless -currency was set explicitly. It exits on an invalid tariff.
+func loadPricing(fs *flag.FlagSet, cfg *config.Config, rate float64, currency string) pricing {
+	var err error
+	p := pricing{currency: currency}
+	if cfg.Currency != "" && !flagWasSet(fs, "currency") {
+		p.currency = cfg.Currency
+	}
+
+	if rate > 0 {
+		p.tariff = tariff.Flat(rate)
+		return p
+	}
+
+	p.tariff, err = cfg.Tariff()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid tariff config: %v\n", err)
+		os.Exit(1)
+	}
+	return p
+}
+
+// flagWasSet reports whether the named flag was given on the command line.
+func flagWasSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// format renders an amount with the currency symbol.
+func (p pricing) format(amount float64, decimals int) string {
+	return fmt.Sprintf("%s%.*f", p.currency, decimals, amount)
+}
+
+// todayUsage returns today's usage from the device's hourly data, falling
+// back to the today_energy total spread over the elapsed hours.
+func todayUsage(now time.Time, hourly *tapo.EnergyData, energyUsage *tapo.EnergyUsage) []tariff.Usage {
+	if hourly != nil && len(hourly.Data) > 0 {
+		return tariff.Hourly(now, hourly.Data)
+	}
+	if energyUsage == nil {
+		return nil
+	}
+	return []tariff.Usage{tariff.Day(now, energyUsage.TodayEnergy, now)}
+}
+
+// monthUsage returns this month's usage: earlier days from the device's daily
+// data and today from todayUsage. Without daily data, month_energy is spread
+// over the month so far.
+func monthUsage(now time.Time, hourly, daily *tapo.EnergyData, energyUsage *tapo.EnergyUsage) []tariff.Usage {
+	todayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	if daily == nil || len(daily.Data) == 0 {
+		if energyUsage == nil {
+			return nil
+		}
+		return []tariff.Usage{{Start: monthStart, End: now, EnergyWh: float64(energyUsage.MonthEnergy)}}
+	}
+
+	start := time.Unix(daily.StartTimestamp, 0).In(now.Location())
+	var usage []tariff.Usage
+	for i, wh := range daily.Data {
+		day := start.AddDate(0, 0, i)
+		if day.Before(monthStart) || !day.Before(todayStart) {
+			continue
+		}
+		usage = append(usage, tariff.Day(day, wh, time.Time{}))
+	}
+	return append(usage, todayUsage(now, hourly, energyUsage)...)
+}
+
+// storeUsage builds usage records for a device from the archive, using hourly
+// records where a day has them and the daily total otherwise.
+func storeUsage(db *store.Store, deviceIP string, start, end time.Time) ([]tariff.Usage, error) {
+	startStr := start.Format("2006-01-02")
+	endStr := end.Format("2006-01-02")
+
+	hourlyRecords, err := db.GetHourlyRange(deviceIP, startStr, endStr)
+	if err != nil {
+		return nil, err
+	}
+	dailyRecords, err := db.GetDailyRange(deviceIP, startStr, endStr)
+	if err != nil {
+		return nil, err
+	}
+
+	byDate := make(map[string][]int)
+	for _, r := range hourlyRecords {
+		if r.Hour < 0 || r.Hour >= 24 {
+			continue
+		}
+		if byDate[r.Date] == nil {
+			byDate[r.Date] = make([]int, 24)
+		}
+		byDate[r.Date][r.Hour] = r.EnergyWh
+	}
+
+	now := time.Now()
+	var usage []tariff.Usage
+	for date, data := range byDate {
+		day, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil {
+			continue
+		}
+		usage = append(usage, tariff.Hourly(day, data)...)
+	}
+	for _, r := range dailyRecords {
+		if byDate[r.Date] != nil {
+			continue
+		}
+		day, err := time.ParseInLocation("2006-01-02", r.Date, time.Local)
+		if err != nil {
+			continue
+		}
+		usage = append(usage, tariff.Day(day, r.EnergyWh, now))
+	}
+	return usage, nil
+}
+
+// historyCosts prices a device's archived usage between start and end. Hourly
+// and daily records are priced directly, together with any part of a monthly
+// total they don't account for (see placeMonthly).
+func historyCosts(db *store.Store, deviceIP string, start, end time.Time, schedule *tariff.Schedule) (tariff.Breakdown, error) {
+	usage, err := storeUsage(db, deviceIP, start, end)
+	if err != nil {
+		return tariff.Breakdown{}, err
+	}
+
+	monthlyRecords, err := db.GetMonthlyRange(deviceIP, start.Year(), end.Year())
+	if err != nil {
+		return tariff.Breakdown{}, err
+	}
+
+	return schedule.Cost(placeMonthly(usage, monthlyRecords, time.Now())), nil
+}
+
+// placeMonthly adds to usage the part of each monthly total that its hourly
+// and daily records don't account for. The remainder is spread over the days
+// of the month without records or, if every day so far has them, over the
+// month's records in proportion to their energy, so that daily charges and
+// slabs are still counted once when everything is priced together.
+func placeMonthly(usage []tariff.Usage, monthly []store.MonthlyRecord, now time.Time) []tariff.Usage {
+	covered := make(map[string]float64)
+	coveredDays := make(map[string]bool)
+	for _, u := range usage {
+		covered[u.Start.Format("2006-01")] += u.EnergyWh
+		coveredDays[u.Start.Format("2006-01-02")] = true
+	}
+
+	for _, r := range monthly {
+		month := fmt.Sprintf("%04d-%02d", r.Year, r.Month)
+		remaining := float64(r.EnergyWh) - covered[month]
+		if remaining <= 0 {
+			continue
+		}
+		u := tariff.Month(r.Year, time.Month(r.Month), r.EnergyWh, time.Local)
+		u.EnergyWh = remaining
+		if u.End.After(now) {
+			u.End = now
+		}
+		if spread := spreadUncovered(u, coveredDays); spread != nil {
+			usage = append(usage, spread...)
+		} else if covered[month] > 0 {
+			usage = append(usage, scaleMonth(usage, month, remaining/covered[month])...)
+		} else {
+			usage = append(usage, u)
+		}
+	}
+	return usage
+}
+
+// scaleMonth returns a copy of the records starting in month with their
+// energy multiplied by factor.
+func scaleMonth(usage []tariff.Usage, month string, factor float64) []tariff.Usage {
+	var scaled []tariff.Usage
+	for _, u := range usage {
+		if u.Start.Format("2006-01") != month || u.EnergyWh == 0 {
+			continue
+		}
+		u.EnergyWh *= factor
+		scaled = append(scaled, u)
+	}
+	return scaled
+}
+
+// spreadUncovered splits u into one record per run of days not in covered,
+// dividing its energy by time. It returns nil if every day is covered.
+func spreadUncovered(u tariff.Usage, covered map[string]bool) []tariff.Usage {
+	var runs []tariff.Usage
+	var total time.Duration
+	for day := u.Start; day.Before(u.End); day = day.AddDate(0, 0, 1) {
+		if covered[day.Format("2006-01-02")] {
+			continue
+		}
+		dayEnd := day.AddDate(0, 0, 1)
+		if dayEnd.After(u.End) {
+			dayEnd = u.End
+		}
+		if n := len(runs); n > 0 && runs[n-1].End.Equal(day) {
+			runs[n-1].End = dayEnd
+		} else {
+			runs = append(runs, tariff.Usage{Start: day, End: dayEnd})
+		}
+		total += dayEnd.Sub(day)
+	}
+	for i := range runs {
+		runs[i].EnergyWh = u.EnergyWh * runs[i].End.Sub(runs[i].Start).Seconds() / total.Seconds()
+	}
+	return runs
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/abhishek/p110/internal/store"
+	"github.com/abhishek/p110/internal/tariff"
+)
+
+func TestPlaceMonthly(t *testing.T) {
+	schedule, err := tariff.NewSchedule([]tariff.Tariff{{
+		Tiers:       []tariff.Tier{{UpToKWh: 1, Rate: 1}, {Rate: 10}},
+		DailyCharge: 0.5,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.Local) }
+	// Every day of the month has a record once now is the end of March 2.
+	now := day(3)
+
+	tests := []struct {
+		name      string
+		usage     []tariff.Usage
+		monthlyWh int
+		wantKWh   float64
+		wantFixed float64
+		wantByDay map[string]float64
+	}{
+		{
+			name:      "covered exactly",
+			usage:     []tariff.Usage{tariff.Day(day(1), 500, now), tariff.Day(day(2), 500, now)},
+			monthlyWh: 1000,
+			wantKWh:   1,
+			wantFixed: 1,
+			wantByDay: map[string]float64{"2024-03-01": 1, "2024-03-02": 1},
+		},
+		{
+			// The extra 1 kWh lands on the recorded days in proportion, so
+			// March 1 uses 0.5 kWh of the first slab and March 2 the other
+			// 0.5 kWh plus 1 kWh of the second. Daily charges count once.
+			name:      "remainder with every day covered",
+			usage:     []tariff.Usage{tariff.Day(day(1), 250, now), tariff.Day(day(2), 750, now)},
+			monthlyWh: 2000,
+			wantKWh:   2,
+			wantFixed: 1,
+			wantByDay: map[string]float64{"2024-03-01": 0.5 + 0.5, "2024-03-02": 0.5 + 0.5 + 10},
+		},
+		{
+			name:      "remainder with no records",
+			monthlyWh: 400,
+			wantKWh:   0.4,
+			wantFixed: 1,
+			wantByDay: map[string]float64{"2024-03-01": 0.7, "2024-03-02": 0.7},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			monthly := []store.MonthlyRecord{{Year: 2024, Month: 3, EnergyWh: tt.monthlyWh}}
+			b := schedule.Cost(placeMonthly(tt.usage, monthly, now))
+
+			if !near(b.KWh, tt.wantKWh) {
+				t.Errorf("KWh = %v, want %v", b.KWh, tt.wantKWh)
+			}
+			if !near(b.Fixed, tt.wantFixed) {
+				t.Errorf("Fixed = %v, want %v", b.Fixed, tt.wantFixed)
+			}
+			var sum float64
+			for d, want := range tt.wantByDay {
+				if !near(b.ByDay[d], want) {
+					t.Errorf("ByDay[%s] = %v, want %v", d, b.ByDay[d], want)
+				}
+			}
+			for _, total := range b.ByDay {
+				sum += total
+			}
+			if !near(sum, b.ByMonth["2024-03"]) {
+				t.Errorf("ByDay sums to %v, ByMonth is %v", sum, b.ByMonth["2024-03"])
+			}
+		})
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
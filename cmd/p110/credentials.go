@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/abhishek/p110/internal/cache"
+	"github.com/abhishek/p110/internal/config"
+	"github.com/abhishek/p110/internal/credentials"
+	"github.com/abhishek/p110/internal/tapo"
+)
+
+// newClient returns a Tapo client for the default account. Credentials are
+// taken from the first of these that has them:
+//
+//   - -username/-password, or TAPO_USERNAME/TAPO_PASSWORD
+//   - the -credentials-file
+//   - the systemd credential "p110"
+//   - the `p110 login` secrets file
+//   - an auth hash in TAPO_AUTH_HASH or the config file
+//
+// It exits if none does.
+func newClient(username, password, credFile string, cfg *config.Config) *tapo.Client {
+	if username == "" {
+		username = os.Getenv("TAPO_USERNAME")
+	}
+	if password == "" {
+		password = os.Getenv("TAPO_PASSWORD")
+	}
+	creds := &credentials.Credentials{Username: username, Password: password}
+
+	if !creds.Valid() && credFile != "" {
+		var err error
+		if creds, err = credentials.ReadFile(credFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if !creds.Valid() {
+		if found := storedCredentials(credentials.DefaultAccount); found != nil {
+			creds = found
+		}
+	}
+	if !creds.Valid() {
+		creds.AuthHash = os.Getenv("TAPO_AUTH_HASH")
+		if creds.AuthHash == "" {
+			creds.AuthHash = cfg.AuthHash
+		}
+	}
+
+	if !creds.Valid() {
+		fmt.Fprintln(os.Stderr, "Error: username and password required")
+		fmt.Fprintln(os.Stderr, "Provide via -username/-password flags, TAPO_USERNAME/TAPO_PASSWORD env vars, -credentials-file,")
+		fmt.Fprintln(os.Stderr, "`p110 login`, or an auth hash from `p110 auth hash` in TAPO_AUTH_HASH or the config file")
+		os.Exit(1)
+	}
+	client, err := creds.Client()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return client
+}
+
+// storedCredentials returns an account's credentials from systemd or the
+// secrets file, or nil if neither has them. It exits if either can't be read.
+func storedCredentials(account string) *credentials.Credentials {
+	creds, err := credentials.FromCredentialsDirectory(account)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if creds != nil {
+		return creds
+	}
+	if s := secretsStore(); s != nil {
+		return s.Account(account)
+	}
+	return nil
+}
+
+// secrets is the decrypted secrets file, opened on first use.
+var secrets *credentials.Store
+
+// secretsStore opens the secrets file, asking for its passphrase once. It
+// returns nil if there is no secrets file.
+func secretsStore() *credentials.Store {
+	path := credentials.DefaultStorePath()
+	if secrets == nil && credentials.StoreExists(path) {
+		passphrase, err := readPassphrase(false)
+		if err == nil {
+			secrets, err = credentials.OpenStore(path, passphrase)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", path, err)
+			os.Exit(1)
+		}
+	}
+	return secrets
+}
+
+// readPassphrase returns the secrets file passphrase from TAPO_PASSPHRASE,
+// the systemd credential "p110-passphrase" or the terminal. A new
+// passphrase typed at the terminal must be entered twice.
+func readPassphrase(confirm bool) (string, error) {
+	if passphrase := os.Getenv(credentials.EnvPassphrase); passphrase != "" {
+		return passphrase, nil
+	}
+	if dir := os.Getenv(credentials.EnvCredentialsDirectory); dir != "" {
+		data, err := os.ReadFile(filepath.Join(dir, "p110-passphrase"))
+		if err == nil {
+			return strings.TrimRight(string(data), "\r\n"), nil
+		}
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("passphrase required: set %s", credentials.EnvPassphrase)
+	}
+	in := bufio.NewReader(os.Stdin)
+	passphrase := prompt(in, "Secrets passphrase: ", true)
+	if passphrase == "" {
+		return "", errors.New("passphrase required")
+	}
+	if confirm && prompt(in, "Repeat passphrase: ", true) != passphrase {
+		return "", errors.New("passphrases don't match")
+	}
+	return passphrase, nil
+}
+
+// accountClients caches the clients for named accounts.
+var accountClients = make(map[string]*tapo.Client)
+
+// accountClient returns a client for a named account from the config file,
+// systemd or the secrets file, exiting if it has no credentials.
+func accountClient(cfg *config.Config, name string) *tapo.Client {
+	if client, ok := accountClients[name]; ok {
+		return client
+	}
+
+	var creds *credentials.Credentials
+	account := cfg.Accounts[name]
+	switch {
+	case account.AuthHash != "":
+		creds = &credentials.Credentials{AuthHash: account.AuthHash}
+	case account.CredentialsFile != "":
+		var err error
+		if creds, err = credentials.ReadFile(account.CredentialsFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: account %q: %v\n", name, err)
+			os.Exit(1)
+		}
+	default:
+		creds = storedCredentials(name)
+	}
+	if creds == nil {
+		fmt.Fprintf(os.Stderr, "Error: no credentials for account %q (add them with p110 login -account %s)\n", name, name)
+		os.Exit(1)
+	}
+
+	client, err := creds.Client()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: account %q: %v\n", name, err)
+		os.Exit(1)
+	}
+	accountClients[name] = client
+	return client
+}
+
+// registerDevices tells the client which cached devices serve KLAP on a
+// non-default port or over HTTPS, and which configured devices belong to
+// another account.
+func registerDevices(client *tapo.Client, cfg *config.Config, devCache *cache.Cache) {
+	for _, e := range devCache.Devices {
+		if e.HTTPPort != 0 || e.HTTPS {
+			client.SetEndpoint(e.IP, e.HTTPPort, e.HTTPS)
+		}
+	}
+
+	for _, d := range cfg.Devices {
+		if d.Account == "" || d.Account == credentials.DefaultAccount {
+			continue
+		}
+		account := accountClient(cfg, d.Account)
+		if d.IP != "" {
+			client.SetCredentials(d.IP, account)
+		}
+		if d.MAC != "" {
+			for _, e := range devCache.Find(d.MAC) {
+				client.SetCredentials(e.IP, account)
+			}
+		}
+	}
+}
+
+// runLogin implements `p110 login`: saves an account's credentials to the
+// encrypted secrets file.
+func runLogin(args []string) {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	account := fs.String("account", credentials.DefaultAccount, "Account name, as used by devices in the config file")
+	username := fs.String("username", os.Getenv("TAPO_USERNAME"), "Tapo account username (email)")
+	authHash := fs.String("auth-hash", "", "Save this auth hash instead of a username and password")
+	fs.Parse(args)
+
+	creds := credentials.Credentials{AuthHash: *authHash}
+	if creds.AuthHash != "" {
+		if _, err := tapo.NewClientWithAuthHash(creds.AuthHash); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		in := bufio.NewReader(os.Stdin)
+		creds.Username = *username
+		if creds.Username == "" {
+			creds.Username = prompt(in, "Username: ", false)
+		}
+		creds.Password = os.Getenv("TAPO_PASSWORD")
+		if creds.Password == "" {
+			creds.Password = prompt(in, "Password: ", true)
+		}
+		if !creds.Valid() {
+			fmt.Fprintln(os.Stderr, "Error: username and password required")
+			os.Exit(1)
+		}
+	}
+
+	path := credentials.DefaultStorePath()
+	passphrase, err := readPassphrase(!credentials.StoreExists(path))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	s, err := credentials.OpenStore(path, passphrase)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	s.Accounts[*account] = creds
+	if err := s.Save(passphrase); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to save secrets: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Saved credentials for account %q to %s\n", *account, path)
+}
+
+// runLogout implements `p110 logout`: removes an account, or every account,
+// from the secrets file.
+func runLogout(args []string) {
+	fs := flag.NewFlagSet("logout", flag.ExitOnError)
+	account := fs.String("account", credentials.DefaultAccount, "Account name to remove")
+	all := fs.Bool("all", false, "Remove the secrets file and every account in it")
+	fs.Parse(args)
+
+	path := credentials.DefaultStorePath()
+	if !credentials.StoreExists(path) {
+		fmt.Println("No saved credentials")
+		return
+	}
+	if *all {
+		if err := os.Remove(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed %s\n", path)
+		return
+	}
+
+	passphrase, err := readPassphrase(false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	s, err := credentials.OpenStore(path, passphrase)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if s.Account(*account) == nil {
+		fmt.Fprintf(os.Stderr, "Error: no saved credentials for account %q\n", *account)
+		os.Exit(1)
+	}
+	delete(s.Accounts, *account)
+	if err := s.Save(passphrase); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to save secrets: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Removed credentials for account %q\n", *account)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/abhishek/p110/internal/cycle"
+	"github.com/abhishek/p110/internal/duration"
+	"github.com/abhishek/p110/internal/store"
+	"github.com/abhishek/p110/internal/tariff"
+)
+
+// cycleRow is one line of `p110 cycles` output.
+type cycleRow struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	DurationS int       `json:"duration_s"`
+	EnergyWh  float64   `json:"energy_wh"`
+	PeakW     float64   `json:"peak_w"`
+	Cost      *float64  `json:"cost,omitempty"`
+}
+
+// runCycles implements `p110 cycles`: detects appliance runs in the archived
+// readings, stores them, and lists them with their cost.
+func runCycles(args []string) {
+	fs := flag.NewFlagSet("cycles", flag.ExitOnError)
+	deviceKey := fs.String("device", "", "Device name (from config), MAC or IP")
+	dbPath := fs.String("db", "p110.db", "SQLite database path")
+	days := fs.Int("days", 7, "Number of days to analyse")
+	startW := fs.Float64("start-w", cycle.DefaultConfig.StartW, "Power (W) at which a cycle starts")
+	stopW := fs.Float64("stop-w", cycle.DefaultConfig.StopW, "Power (W) below which a cycle may end")
+	minOff := fs.Duration("min-off", time.Duration(cycle.DefaultConfig.MinOff), "Time below -stop-w before a cycle ends")
+	minDuration := fs.Duration("min-duration", time.Duration(cycle.DefaultConfig.MinDuration), "Ignore cycles shorter than this")
+	rate := fs.Float64("rate", 0, "Electricity rate per kWh for cost calculation (overrides config tariffs)")
+	currency := fs.String("currency", "₹", "Currency symbol for cost display")
+	configPath := fs.String("config", "", "Config file path (default $P110_CONFIG)")
+	jsonOutput := fs.Bool("json", false, "Output in JSON format")
+	fs.Parse(args)
+
+	if *deviceKey == "" {
+		fmt.Fprintln(os.Stderr, "Error: -device is required")
+		os.Exit(1)
+	}
+
+	cfg := loadConfig(*configPath)
+	prices := loadPricing(fs, cfg, *rate, *currency)
+
+	db, err := store.Open(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	deviceIP, dev, err := resolveArchivedDevice(db, cfg, *deviceKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Defaults, then per-device config, then explicit flags.
+	detectCfg := cycle.DefaultConfig
+	if dev != nil && dev.Cycle != nil {
+		detectCfg = *dev.Cycle
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "start-w":
+			detectCfg.StartW = *startW
+		case "stop-w":
+			detectCfg.StopW = *stopW
+		case "min-off":
+			detectCfg.MinOff = duration.Duration(*minOff)
+		case "min-duration":
+			detectCfg.MinDuration = duration.Duration(*minDuration)
+		}
+	})
+
+	end := time.Now()
+	start := end.AddDate(0, 0, -*days)
+
+	readings, err := db.GetReadingsRange(deviceIP, start, end)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read readings: %v\n", err)
+		os.Exit(1)
+	}
+
+	samples := make([]cycle.Sample, len(readings))
+	for i, r := range readings {
+		samples[i] = cycle.Sample{Time: r.Timestamp, PowerW: float64(r.PowerMW) / 1000.0}
+	}
+
+	for _, c := range cycle.Detect(detectCfg, samples) {
+		err := db.InsertCycle(store.CycleRecord{
+			DeviceIP: deviceIP,
+			Start:    c.Start,
+			End:      c.End,
+			EnergyWh: c.EnergyWh,
+			PeakMW:   int(c.PeakW * 1000),
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to store cycle: %v\n", err)
+		}
+	}
+
+	records, err := db.GetCyclesRange(deviceIP, start, end)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read cycles: %v\n", err)
+		os.Exit(1)
+	}
+
+	rows := make([]cycleRow, len(records))
+	for i, r := range records {
+		rows[i] = cycleRow{
+			Start:     r.Start.Local(),
+			End:       r.End.Local(),
+			DurationS: int(r.End.Sub(r.Start).Seconds()),
+			EnergyWh:  r.EnergyWh,
+			PeakW:     float64(r.PeakMW) / 1000.0,
+		}
+		if prices.tariff != nil {
+			cost := prices.tariff.Cost([]tariff.Usage{{Start: rows[i].Start, End: rows[i].End, EnergyWh: r.EnergyWh}}).Energy
+			rows[i].Cost = &cost
+		}
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rows)
+		return
+	}
+
+	name := *deviceKey
+	if name != deviceIP {
+		name += " (" + deviceIP + ")"
+	}
+	fmt.Printf("Cycles for %s (last %d days)\n", name, *days)
+	fmt.Println(strings.Repeat("─", 70))
+
+	if len(rows) == 0 {
+		fmt.Println("  No cycles found")
+		return
+	}
+
+	fmt.Println("  Start             End      Duration    Energy     Peak     Cost")
+	var totalWh, totalCost float64
+	for _, r := range rows {
+		cost := ""
+		if r.Cost != nil {
+			cost = prices.format(*r.Cost, 2)
+			totalCost += *r.Cost
+		}
+		totalWh += r.EnergyWh
+		fmt.Printf("  %s  %s  %8s  %6.0f Wh  %6.0f W  %s\n",
+			r.Start.Format("2006-01-02 15:04"), r.End.Format("15:04"),
+			time.Duration(r.DurationS)*time.Second, r.EnergyWh, r.PeakW, cost)
+	}
+
+	fmt.Printf("  %d cycles, %.2f kWh", len(rows), totalWh/1000.0)
+	if prices.tariff != nil {
+		fmt.Printf(" (%s)", prices.format(totalCost, 2))
+	}
+	fmt.Println()
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhishek/p110/internal/config"
+	"github.com/abhishek/p110/internal/store"
+	"github.com/abhishek/p110/internal/tapo"
+)
+
+// Event kinds recorded in the events table.
+const (
+	eventPower           = "power"            // switched on or off
+	eventReboot          = "reboot"           // on_time went backwards while on
+	eventIP              = "ip"               // the device's address changed
+	eventConnection      = "connection"       // stopped or started answering
+	eventOverheated      = "overheated"       // overheat protection tripped or cleared
+	eventPowerProtection = "power_protection" // overload protection status changed
+	eventFirmware        = "firmware"         // firmware was updated
+)
+
+// deviceSnapshot is the part of a device's state that the daemon watches for
+// changes between polls.
+type deviceSnapshot struct {
+	mac        string
+	nickname   string // not compared; names the device while it's offline
+	ip         string
+	online     bool
+	on         bool
+	onTime     int // seconds
+	overheated bool
+	protection string
+	firmware   string
+}
+
+func snapshotFromInfo(info *tapo.DeviceInfo) deviceSnapshot {
+	return deviceSnapshot{
+		mac:        info.MAC,
+		nickname:   info.Nickname,
+		ip:         info.IP,
+		online:     true,
+		on:         info.DeviceON,
+		onTime:     info.OnTime,
+		overheated: info.OverHeated,
+		protection: info.PowerProtectionStatus,
+		firmware:   info.FirmwareVersion,
+	}
+}
+
+func snapshotFromChild(c tapo.ChildInfo) deviceSnapshot {
+	return deviceSnapshot{
+		online:     true,
+		on:         c.DeviceON,
+		onTime:     c.OnTime,
+		overheated: c.OverHeated,
+		firmware:   c.FirmwareVersion,
+	}
+}
+
+// diffSnapshots returns the events that explain the change from prev to cur.
+// Fields a device doesn't report are left empty and never differ.
+func diffSnapshots(prev, cur deviceSnapshot) []store.EventRecord {
+	var events []store.EventRecord
+	add := func(kind, oldValue, newValue string) {
+		events = append(events, store.EventRecord{Kind: kind, OldValue: oldValue, NewValue: newValue})
+	}
+
+	if prev.online != cur.online {
+		add(eventConnection, onlineString(prev.online), onlineString(cur.online))
+	}
+	if !cur.online {
+		// Nothing else is known about an unreachable device.
+		return events
+	}
+	if prev.ip != "" && cur.ip != "" && prev.ip != cur.ip {
+		add(eventIP, prev.ip, cur.ip)
+	}
+	if prev.on != cur.on {
+		add(eventPower, onOffString(prev.on), onOffString(cur.on))
+	} else if cur.on && cur.onTime < prev.onTime {
+		add(eventReboot, onTimeString(prev.onTime), onTimeString(cur.onTime))
+	}
+	if prev.overheated != cur.overheated {
+		add(eventOverheated, strconv.FormatBool(prev.overheated), strconv.FormatBool(cur.overheated))
+	}
+	if prev.protection != cur.protection && prev.protection != "" && cur.protection != "" {
+		add(eventPowerProtection, prev.protection, cur.protection)
+	}
+	if prev.firmware != cur.firmware && prev.firmware != "" && cur.firmware != "" {
+		add(eventFirmware, prev.firmware, cur.firmware)
+	}
+	return events
+}
+
+func onlineString(online bool) string {
+	if online {
+		return "online"
+	}
+	return "offline"
+}
+
+func onOffString(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+func onTimeString(seconds int) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+// recordSnapshot compares a device's state with the previous poll and
+// stores what changed. The first poll of a device after the daemon starts
+// only sets the baseline, apart from an address change, which is checked
+// against the readings table.
+func (p *poller) recordSnapshot(deviceKey string, cur deviceSnapshot, now time.Time) {
+	prev, seen := p.snapshots[deviceKey]
+	if !seen {
+		prev = cur
+		if cur.mac != "" {
+			if ip, err := p.db.GetDeviceIPByMAC(cur.mac); err == nil && ip != "" {
+				prev.ip = ip
+			}
+		}
+	}
+	if !cur.online {
+		// Keep the last known state to compare with when it comes back.
+		cur = prev
+		cur.online = false
+	}
+	p.snapshots[deviceKey] = cur
+
+	for _, e := range diffSnapshots(prev, cur) {
+		e.Timestamp = now
+		e.DeviceIP = deviceKey
+		e.DeviceMAC = cur.mac
+		log.Printf("[%s] Event %s: %s -> %s", deviceKey, e.Kind, e.OldValue, e.NewValue)
+		if err := p.db.InsertEvent(e); err != nil {
+			log.Printf("[%s] Failed to store event: %v", deviceKey, err)
+		}
+	}
+}
+
+// recordOffline notes that a device didn't answer a poll.
+func (p *poller) recordOffline(deviceKey string, now time.Time) {
+	if _, ok := p.snapshots[deviceKey]; ok {
+		p.recordSnapshot(deviceKey, deviceSnapshot{}, now)
+	}
+}
+
+// eventRow is one line of `p110 events` output.
+type eventRow struct {
+	Time      time.Time `json:"time"`
+	DeviceIP  string    `json:"device_ip"`
+	DeviceMAC string    `json:"device_mac,omitempty"`
+	Kind      string    `json:"kind"`
+	OldValue  string    `json:"old_value"`
+	NewValue  string    `json:"new_value"`
+}
+
+// runEvents implements `p110 events`: lists device state changes recorded
+// by the daemon.
+func runEvents(args []string) {
+	fs := flag.NewFlagSet("events", flag.ExitOnError)
+	deviceKey := fs.String("device", "", "Device name (from config), MAC or IP; empty for all devices")
+	dbPath := fs.String("db", "p110.db", "SQLite database path")
+	since := fs.String("since", "7d", "Show events since this long ago (e.g. 12h, 7d) or since a date (YYYY-MM-DD)")
+	kind := fs.String("kind", "", "Only show events of this kind (power, reboot, ip, connection, overheated, power_protection, firmware)")
+	configPath := fs.String("config", "", "Config file path (default $P110_CONFIG)")
+	jsonOutput := fs.Bool("json", false, "Output in JSON format")
+	fs.Parse(args)
+
+	start, err := parseSince(*since, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	db, err := store.Open(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	deviceIP, deviceMAC := "", ""
+	if *deviceKey != "" {
+		cfg := loadConfig(*configPath)
+		var dev *config.Device
+		deviceIP, dev, err = resolveArchivedDevice(db, cfg, *deviceKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if dev != nil && dev.MAC != "" {
+			deviceMAC = config.NormalizeMAC(dev.MAC)
+		} else if _, err := net.ParseMAC(*deviceKey); err == nil {
+			deviceMAC = config.NormalizeMAC(*deviceKey)
+		}
+	}
+
+	records, err := db.GetEventsSince(deviceIP, deviceMAC, start)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read events: %v\n", err)
+		os.Exit(1)
+	}
+
+	rows := []eventRow{}
+	for _, r := range records {
+		if *kind != "" && r.Kind != *kind {
+			continue
+		}
+		rows = append(rows, eventRow{
+			Time:      r.Timestamp.Local(),
+			DeviceIP:  r.DeviceIP,
+			DeviceMAC: r.DeviceMAC,
+			Kind:      r.Kind,
+			OldValue:  r.OldValue,
+			NewValue:  r.NewValue,
+		})
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rows)
+		return
+	}
+
+	fmt.Printf("Device events since %s\n", start.Format("2006-01-02 15:04"))
+	fmt.Println(strings.Repeat("─", 70))
+
+	if len(rows) == 0 {
+		fmt.Println("  No events recorded")
+		return
+	}
+
+	for _, r := range rows {
+		fmt.Printf("  %s  %-15s  %-16s  %s → %s\n",
+			r.Time.Format("2006-01-02 15:04"), r.DeviceIP, r.Kind, r.OldValue, r.NewValue)
+	}
+}
+
+// parseSince parses a -since value: a duration before now, which may be
+// given in days ("7d"), or a date in local time.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("invalid -since %q", s)
+		}
+		return now.AddDate(0, 0, -n), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid -since %q (want e.g. 12h, 7d or 2006-01-02)", s)
+	}
+	return now.Add(-d), nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/abhishek/p110/internal/tapo"
+)
+
+// firmwareRow is one line of `p110 firmware status` output.
+type firmwareRow struct {
+	Device    string `json:"device"`
+	Name      string `json:"name,omitempty"`
+	Model     string `json:"model,omitempty"`
+	Current   string `json:"current,omitempty"`
+	Latest    string `json:"latest,omitempty"`
+	Available bool   `json:"update_available"`
+	Error     string `json:"error,omitempty"`
+}
+
+// runFirmware implements `p110 firmware status|update`.
+func runFirmware(args []string) {
+	if len(args) == 0 || (args[0] != "status" && args[0] != "update") {
+		fmt.Fprintln(os.Stderr, "Usage: p110 firmware status|update [flags]")
+		os.Exit(1)
+	}
+	verb, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("firmware", flag.ExitOnError)
+	conn := addConnFlags(fs)
+	jsonOutput := fs.Bool("json", false, "Output in JSON format")
+	force := fs.Bool("force", false, "Allow updating several devices at once")
+	reinstall := fs.Bool("reinstall", false, "Install the latest firmware even on devices already running it")
+	wait := fs.Duration("wait", 5*time.Minute, "How long to wait for the device to come back after updating")
+	fs.Parse(args)
+
+	client := conn.client()
+
+	if verb == "status" {
+		// The fleet view covers every device unless one is named.
+		if *conn.ip == "" {
+			*conn.all = true
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		deviceIPs := conn.deviceIPs(ctx)
+		cancel()
+		firmwareStatus(client, deviceIPs, *jsonOutput)
+		return
+	}
+
+	if *conn.ip == "" && !*conn.all {
+		fmt.Fprintln(os.Stderr, "Error: firmware update requires -ip (or -all -force)")
+		os.Exit(1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	deviceIPs := conn.deviceIPs(ctx)
+	cancel()
+
+	if len(deviceIPs) > 1 && !*force {
+		fmt.Fprintf(os.Stderr, "Error: refusing to update %d devices at once; use -force\n", len(deviceIPs))
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, ip := range deviceIPs {
+		if err := updateFirmware(client, ip, *reinstall, *wait); err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] Update failed: %v\n", ip, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// firmwareStatus lists current and available firmware for each device.
+func firmwareStatus(client *tapo.Client, deviceIPs []string, jsonOutput bool) {
+	var rows []firmwareRow
+	for _, ip := range deviceIPs {
+		row := firmwareRow{Device: ip}
+
+		device, err := client.Connect(ip)
+		if err != nil {
+			row.Error = err.Error()
+			rows = append(rows, row)
+			continue
+		}
+
+		if info, err := device.GetDeviceInfo(); err != nil {
+			row.Error = err.Error()
+		} else {
+			row.Name = info.Nickname
+			row.Model = info.Model
+			row.Current = firmwareVersion(info.FirmwareVersion)
+		}
+
+		if fw, err := device.GetLatestFirmware(); err != nil {
+			if row.Error == "" {
+				row.Error = err.Error()
+			}
+		} else {
+			row.Latest = firmwareVersion(fw.Version)
+			row.Available = fw.NeedToUpgrade
+			if row.Latest == "" {
+				row.Latest = row.Current
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rows)
+		return
+	}
+
+	fmt.Printf("%-15s  %-20s  %-7s  %-10s  %-10s  %s\n", "Device", "Name", "Model", "Current", "Latest", "")
+	fmt.Println(strings.Repeat("─", 78))
+	updates := 0
+	for _, r := range rows {
+		note := ""
+		switch {
+		case r.Error != "":
+			note = "error: " + r.Error
+		case r.Available:
+			note = "update available"
+			updates++
+		}
+		fmt.Printf("%-15s  %-20s  %-7s  %-10s  %-10s  %s\n", r.Device, truncate(r.Name, 20), r.Model, r.Current, r.Latest, note)
+	}
+	fmt.Printf("\n%d of %d device(s) have an update available\n", updates, len(rows))
+}
+
+// firmwareVersion trims the build suffix from a version like
+// "1.3.0 Build 230905 Rel.152200".
+func firmwareVersion(v string) string {
+	if i := strings.Index(v, " "); i > 0 {
+		return v[:i]
+	}
+	return v
+}
+
+// updateFirmware installs the latest firmware on one device, reporting
+// download progress and waiting for the device to come back running it.
+// With reinstall, devices that are up to date are updated anyway.
+func updateFirmware(client *tapo.Client, ip string, reinstall bool, wait time.Duration) error {
+	device, err := client.Connect(ip)
+	if err != nil {
+		return err
+	}
+
+	info, err := device.GetDeviceInfo()
+	if err != nil {
+		return err
+	}
+	fw, err := device.GetLatestFirmware()
+	if err != nil {
+		return fmt.Errorf("failed to check for updates: %w", err)
+	}
+	if !fw.NeedToUpgrade && !reinstall {
+		fmt.Printf("[%s] Already up to date (%s)\n", ip, firmwareVersion(info.FirmwareVersion))
+		return nil
+	}
+
+	fmt.Printf("[%s] Updating %s from %s to %s\n", ip, info.Model,
+		firmwareVersion(info.FirmwareVersion), firmwareVersion(fw.Version))
+	if err := device.DownloadFirmware(); err != nil {
+		return fmt.Errorf("failed to start download: %w", err)
+	}
+
+	// Poll progress until the device stops answering, which means it is
+	// flashing and rebooting.
+	deadline := time.Now().Add(wait)
+	lastProgress := -1
+	rebooted := false // the device has stopped answering since the download started
+	for time.Now().Before(deadline) {
+		time.Sleep(2 * time.Second)
+		state, err := device.GetFirmwareDownloadState()
+		if err != nil {
+			rebooted = true
+			break
+		}
+		if state.DownloadProgress != lastProgress {
+			fmt.Printf("[%s] Downloading: %d%%\n", ip, state.DownloadProgress)
+			lastProgress = state.DownloadProgress
+		}
+	}
+
+	fmt.Printf("[%s] Installing and rebooting...\n", ip)
+	for time.Now().Before(deadline) {
+		time.Sleep(5 * time.Second)
+		device, err := client.Connect(ip)
+		if err != nil {
+			rebooted = true
+			continue
+		}
+		newInfo, err := device.GetDeviceInfo()
+		if err != nil {
+			rebooted = true
+			continue
+		}
+		// A reinstall keeps the version, so only the reboot shows it is done.
+		if newInfo.FirmwareVersion == info.FirmwareVersion && (!reinstall || !rebooted) {
+			continue // still running the old image
+		}
+		current := firmwareVersion(newInfo.FirmwareVersion)
+		if fw.Version != "" && current != firmwareVersion(fw.Version) {
+			return fmt.Errorf("device came back running %s, expected %s", current, firmwareVersion(fw.Version))
+		}
+		fmt.Printf("[%s] Now running %s\n", ip, current)
+		return nil
+	}
+
+	return fmt.Errorf("device did not come back with new firmware within %s", wait)
+}
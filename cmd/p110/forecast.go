@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/abhishek/p110/internal/forecast"
+	"github.com/abhishek/p110/internal/store"
+	"github.com/abhishek/p110/internal/tapo"
+	"github.com/abhishek/p110/internal/tariff"
+)
+
+// runawayRatio flags a device whose projection exceeds last month (or whose
+// recent trend grows) by this factor.
+const runawayRatio = 1.25
+
+// deviceForecast is one row of `p110 forecast` output.
+type deviceForecast struct {
+	Device         string   `json:"device"`
+	Name           string   `json:"name,omitempty"`
+	Source         string   `json:"source"` // "device" or "store"
+	MonthToDateKWh float64  `json:"month_to_date_kwh"`
+	ProjectedKWh   float64  `json:"projected_kwh"`
+	LowKWh         float64  `json:"low_kwh"`
+	HighKWh        float64  `json:"high_kwh"`
+	ProjectedCost  *float64 `json:"projected_cost,omitempty"`
+	LowCost        *float64 `json:"low_cost,omitempty"`
+	HighCost       *float64 `json:"high_cost,omitempty"`
+	LastMonthKWh   *float64 `json:"last_month_kwh,omitempty"`
+	LastYearKWh    *float64 `json:"last_year_kwh,omitempty"`
+	Trend          float64  `json:"trend,omitempty"`
+	Runaway        bool     `json:"runaway"`
+}
+
+// runForecast implements `p110 forecast`: projected month-end energy and cost.
+func runForecast(args []string) {
+	fs := flag.NewFlagSet("forecast", flag.ExitOnError)
+	conn := addConnFlags(fs)
+	dbPath := fs.String("db", "p110.db", "SQLite database path")
+	rate := fs.Float64("rate", 0, "Electricity rate per kWh for cost calculation (overrides config tariffs)")
+	currency := fs.String("currency", "₹", "Currency symbol for cost display")
+	jsonOutput := fs.Bool("json", false, "Output in JSON format")
+	fs.Parse(args)
+
+	cfg := conn.config()
+	prices := loadPricing(fs, cfg, *rate, *currency)
+	client := conn.client()
+
+	db, err := store.Open(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	deviceIPs := conn.deviceIPs(ctx)
+	cancel()
+
+	now := time.Now()
+	var rows []deviceForecast
+	var fleetUsage []tariff.Usage // projected usage of every priced device
+	for _, deviceIP := range deviceIPs {
+		in := forecast.Input{Now: now}
+		row := deviceForecast{Device: deviceIP, Source: "device"}
+
+		device, err := client.Connect(deviceIP)
+		if err == nil {
+			if info, err := device.GetDeviceInfo(); err == nil {
+				row.Name = info.Nickname
+			}
+			usage, uerr := device.GetEnergyUsage()
+			if errors.Is(uerr, tapo.ErrNotSupported) {
+				continue // no energy metering
+			}
+			if uerr == nil {
+				in.MonthToDateWh = usage.MonthEnergy
+				in.TodayWh = usage.TodayEnergy
+			}
+			err = uerr
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] Using archived data: %v\n", deviceIP, err)
+			row.Source = "store"
+			in.MonthToDateWh, in.TodayWh, err = storedMonthToDate(db, deviceIP, now)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] Failed to read archive: %v\n", deviceIP, err)
+				continue
+			}
+		}
+
+		start := now.AddDate(0, 0, -8*7)
+		yesterday := now.AddDate(0, 0, -1)
+		dailyRecords, err := db.GetDailyRange(deviceIP, start.Format("2006-01-02"), yesterday.Format("2006-01-02"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] Failed to read daily history: %v\n", deviceIP, err)
+		}
+		for _, r := range dailyRecords {
+			if date, err := time.ParseInLocation("2006-01-02", r.Date, time.Local); err == nil {
+				in.History = append(in.History, forecast.Day{Date: date, EnergyWh: r.EnergyWh})
+			}
+		}
+
+		f := forecast.Project(in)
+		row.MonthToDateKWh = f.MonthToDateWh / 1000.0
+		row.ProjectedKWh = f.ProjectedWh / 1000.0
+		row.LowKWh = f.LowWh / 1000.0
+		row.HighKWh = f.HighWh / 1000.0
+		row.Trend = f.Trend
+
+		lastMonth := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, now.Location())
+		monthlyRecords, _ := db.GetMonthlyRange(deviceIP, now.Year()-1, now.Year())
+		for _, r := range monthlyRecords {
+			kwh := float64(r.EnergyWh) / 1000.0
+			if r.Year == lastMonth.Year() && time.Month(r.Month) == lastMonth.Month() {
+				row.LastMonthKWh = &kwh
+			}
+			if r.Year == now.Year()-1 && time.Month(r.Month) == now.Month() {
+				row.LastYearKWh = &kwh
+			}
+		}
+
+		row.Runaway = f.Trend >= runawayRatio ||
+			(row.LastMonthKWh != nil && *row.LastMonthKWh > 0 && row.ProjectedKWh >= *row.LastMonthKWh*runawayRatio)
+
+		if prices.tariff != nil {
+			low, mid, high, usage, err := forecastCosts(db, deviceIP, now, in, f, prices.tariff)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] Failed to price forecast: %v\n", deviceIP, err)
+			} else {
+				row.LowCost, row.ProjectedCost, row.HighCost = &low, &mid, &high
+				fleetUsage = append(fleetUsage, usage...)
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	if len(rows) == 0 {
+		fmt.Fprintln(os.Stderr, "No forecasts available")
+		os.Exit(1)
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rows)
+		return
+	}
+
+	// The household pays one bill: a single daily charge, with every
+	// device's energy climbing the same slabs.
+	var fleetCost *float64
+	if len(fleetUsage) > 0 {
+		cost := prices.tariff.Cost(fleetUsage).Total
+		fleetCost = &cost
+	}
+	printForecast(rows, now, prices, fleetCost)
+}
+
+// storedMonthToDate reads month-to-date and today's energy from the daily table.
+func storedMonthToDate(db *store.Store, deviceIP string, now time.Time) (int, int, error) {
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	todayStr := now.Format("2006-01-02")
+
+	records, err := db.GetDailyRange(deviceIP, monthStart.Format("2006-01-02"), todayStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(records) == 0 {
+		return 0, 0, fmt.Errorf("no daily records this month")
+	}
+
+	var month, today int
+	for _, r := range records {
+		month += r.EnergyWh
+		if r.Date == todayStr {
+			today = r.EnergyWh
+		}
+	}
+	return month, today, nil
+}
+
+// forecastCosts prices the month so far plus the projected remainder, scaling
+// the remainder to the low and high ends of the band. It also returns the
+// usage behind the projected cost, so a fleet total can be priced as one bill.
+func forecastCosts(db *store.Store, deviceIP string, now time.Time, in forecast.Input, f forecast.Forecast, schedule *tariff.Schedule) (low, mid, high float64, usage []tariff.Usage, err error) {
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	todayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	var past []tariff.Usage
+	if todayStart.After(monthStart) {
+		past, err = storeUsage(db, deviceIP, monthStart, todayStart.AddDate(0, 0, -1))
+		if err != nil {
+			return 0, 0, 0, nil, err
+		}
+	}
+	covered := 0.0
+	for _, u := range past {
+		covered += u.EnergyWh
+	}
+	if rest := float64(in.MonthToDateWh-in.TodayWh) - covered; rest > 0 && todayStart.After(monthStart) {
+		past = append(past, tariff.Usage{Start: monthStart, End: todayStart, EnergyWh: rest})
+	}
+	past = append(past, tariff.Day(now, in.TodayWh, now))
+
+	remaining := f.ProjectedWh - f.MonthToDateWh
+	project := func(target float64) []tariff.Usage {
+		scale := 0.0
+		if remaining > 0 {
+			scale = (target - f.MonthToDateWh) / remaining
+		}
+		projected := append([]tariff.Usage(nil), past...)
+		for i, d := range f.Remaining {
+			u := tariff.Day(d.Date, 0, time.Time{})
+			if i == 0 {
+				u.Start = now
+			}
+			u.EnergyWh = float64(d.EnergyWh) * scale
+			projected = append(projected, u)
+		}
+		return projected
+	}
+
+	usage = project(f.ProjectedWh)
+	low = schedule.Cost(project(f.LowWh)).Total
+	mid = schedule.Cost(usage).Total
+	high = schedule.Cost(project(f.HighWh)).Total
+	return low, mid, high, usage, nil
+}
+
+// printForecast renders the forecast table with a total row. fleetCost is
+// the projected usage of all devices priced together, if there is a tariff.
+// The total band combines the devices' spreads in quadrature, as they vary
+// independently.
+func printForecast(rows []deviceForecast, now time.Time, prices pricing, fleetCost *float64) {
+	monthEnd := time.Date(now.Year(), now.Month()+1, 0, 0, 0, 0, 0, now.Location())
+	fmt.Printf("Forecast for %s (day %d of %d)\n", now.Format("January 2006"), now.Day(), monthEnd.Day())
+	fmt.Println(strings.Repeat("─", 100))
+	fmt.Printf("%-20s %9s %10s %17s %12s %10s %10s %7s\n",
+		"Device", "MTD kWh", "Proj kWh", "80% band", "Cost", "Last mon", "Last year", "Trend")
+
+	var total deviceForecast
+	var lowSpread, highSpread, lastMonth, lastYear float64
+	var haveLastMonth, haveLastYear bool
+
+	for _, r := range rows {
+		name := r.Name
+		if name == "" {
+			name = r.Device
+		}
+		if r.Source == "store" {
+			name += "*"
+		}
+		printForecastRow(truncate(name, 20), r, prices)
+
+		total.MonthToDateKWh += r.MonthToDateKWh
+		total.ProjectedKWh += r.ProjectedKWh
+		lowSpread += (r.ProjectedKWh - r.LowKWh) * (r.ProjectedKWh - r.LowKWh)
+		highSpread += (r.HighKWh - r.ProjectedKWh) * (r.HighKWh - r.ProjectedKWh)
+		if r.LastMonthKWh != nil {
+			lastMonth += *r.LastMonthKWh
+			haveLastMonth = true
+		}
+		if r.LastYearKWh != nil {
+			lastYear += *r.LastYearKWh
+			haveLastYear = true
+		}
+	}
+
+	if len(rows) > 1 {
+		total.LowKWh = math.Max(total.ProjectedKWh-math.Sqrt(lowSpread), total.MonthToDateKWh)
+		total.HighKWh = total.ProjectedKWh + math.Sqrt(highSpread)
+		total.ProjectedCost = fleetCost
+		if haveLastMonth {
+			total.LastMonthKWh = &lastMonth
+		}
+		if haveLastYear {
+			total.LastYearKWh = &lastYear
+		}
+		fmt.Println(strings.Repeat("─", 100))
+		printForecastRow("Total", total, prices)
+	}
+
+	fmt.Println(strings.Repeat("─", 100))
+	fmt.Println("* from archived data (device unreachable).  ⚠ projection or trend well above normal.")
+}
+
+func printForecastRow(name string, r deviceForecast, prices pricing) {
+	cost := "-"
+	if r.ProjectedCost != nil {
+		cost = prices.format(*r.ProjectedCost, 0)
+	}
+	lastMonth := "-"
+	if r.LastMonthKWh != nil {
+		lastMonth = fmt.Sprintf("%.2f", *r.LastMonthKWh)
+	}
+	lastYear := "-"
+	if r.LastYearKWh != nil {
+		lastYear = fmt.Sprintf("%.2f", *r.LastYearKWh)
+	}
+	trend := "-"
+	if r.Trend > 0 {
+		trend = fmt.Sprintf("%+.0f%%", (r.Trend-1)*100)
+	}
+	marker := ""
+	if r.Runaway {
+		marker = " ⚠"
+	}
+
+	fmt.Printf("%-20s %9.2f %10.2f %8.2f-%-8.2f %12s %10s %10s %7s%s\n",
+		name, r.MonthToDateKWh, r.ProjectedKWh, r.LowKWh, r.HighKWh, cost, lastMonth, lastYear, trend, marker)
+}
@@ -3,17 +3,25 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/abhishek/p110/internal/alert"
+	"github.com/abhishek/p110/internal/automation"
+	"github.com/abhishek/p110/internal/cache"
+	"github.com/abhishek/p110/internal/config"
 	"github.com/abhishek/p110/internal/store"
+	"github.com/abhishek/p110/internal/stream"
 	"github.com/abhishek/p110/internal/tapo"
+	"github.com/abhishek/p110/internal/tariff"
 )
 
 type outputMode int
@@ -25,14 +33,29 @@ const (
 )
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
 	// Connection flags
 	username := flag.String("username", "", "Tapo account username (email)")
 	password := flag.String("password", "", "Tapo account password")
+	credFile := flag.String("credentials-file", "", "JSON file with the account credentials (must be mode 0600)")
 	ip := flag.String("ip", "", "Device IP address (optional, will auto-discover if not provided)")
 	timeout := flag.Duration("timeout", 5*time.Second, "Discovery timeout")
+	iface := flag.String("iface", "", "Discover only on these network interfaces (comma-separated)")
+	subnet := flag.String("subnet", "", "Discover by directed broadcast to these CIDRs (comma-separated)")
+	scan := flag.String("scan", "", "Also probe every address in these CIDRs by unicast (comma-separated)")
+	cachePath := flag.String("cache", cache.DefaultPath(), "Device cache file")
+	refresh := flag.Bool("refresh", false, "Rediscover devices instead of trusting the cache")
+	kasa := flag.Bool("kasa", false, "Also discover Kasa devices on port 9999")
 
 	// Query mode flags
-	all := flag.Bool("all", false, "Query all discovered devices")
+	all := flag.Bool("all", false, "Query all cached devices; discover if the cache is empty or stale, or with -refresh (daemon mode always discovers)")
 	discover := flag.Bool("discover", false, "Only discover devices, don't connect")
 	jsonOutput := flag.Bool("json", false, "Output in JSON format")
 	raw := flag.Bool("raw", false, "Output raw data (verbose)")
@@ -42,13 +65,16 @@ func main() {
 	turnOff := flag.Bool("off", false, "Turn device off (requires -ip)")
 
 	// Display flags
-	rate := flag.Float64("rate", 0, "Electricity rate per kWh for cost calculation")
+	rate := flag.Float64("rate", 0, "Electricity rate per kWh for cost calculation (overrides config tariffs)")
 	currency := flag.String("currency", "₹", "Currency symbol for cost display")
+	configPath := flag.String("config", "", "Config file path (default $P110_CONFIG)")
 
 	// Daemon mode flags
 	daemon := flag.Bool("daemon", false, "Run in daemon mode, periodically collecting data")
 	interval := flag.Duration("interval", 5*time.Minute, "Polling interval for daemon mode")
 	dbPath := flag.String("db", "p110.db", "SQLite database path for daemon mode")
+	listen := flag.String("listen", "", "HTTP address for the live power stream in daemon mode (e.g. :8110)")
+	dryRun := flag.Bool("dry-run", false, "Log and audit automation actions without switching plugs")
 
 	// History viewing flags
 	history := flag.Bool("history", false, "View historical data from database")
@@ -56,13 +82,7 @@ func main() {
 
 	flag.Parse()
 
-	// Check environment variables if flags not provided
-	if *username == "" {
-		*username = os.Getenv("TAPO_USERNAME")
-	}
-	if *password == "" {
-		*password = os.Getenv("TAPO_PASSWORD")
-	}
+	disc := newDiscoverer(*timeout, *iface, *subnet, *scan, *kasa)
 
 	// Validate mutually exclusive flags
 	if *turnOn && *turnOff {
@@ -70,6 +90,9 @@ func main() {
 		os.Exit(1)
 	}
 
+	cfg := loadConfig(*configPath)
+	prices := loadPricing(flag.CommandLine, cfg, *rate, *currency)
+
 	// Determine output mode
 	mode := modeSummary
 	if *jsonOutput {
@@ -80,13 +103,13 @@ func main() {
 
 	// Daemon mode
 	if *daemon {
-		runDaemon(*username, *password, *ip, *all, *dbPath, *interval, *timeout)
+		runDaemon(newClient(*username, *password, *credFile, cfg), *ip, *all, *dbPath, *listen, cfg, *interval, disc, loadDeviceCache(*cachePath), *dryRun)
 		return
 	}
 
 	// History viewing mode
 	if *history {
-		showHistory(*dbPath, *ip, *days, *rate, *currency)
+		showHistory(*dbPath, *ip, *days, prices)
 		return
 	}
 
@@ -96,7 +119,7 @@ func main() {
 
 	// Discovery-only mode
 	if *discover {
-		devices, err := tapo.DiscoverWithTimeout(ctx, *timeout)
+		devices, err := disc.All(ctx)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Discovery failed: %v\n", err)
 			os.Exit(1)
@@ -114,61 +137,47 @@ func main() {
 		} else {
 			fmt.Printf("Found %d device(s):\n", len(devices))
 			for _, d := range devices {
-				fmt.Printf("  - IP: %s, Model: %s, MAC: %s\n", d.IP, d.Model, d.MAC)
+				fmt.Printf("  - IP: %s, Model: %s, MAC: %s, Encryption: %s, Probes answered: %d",
+					d.IP, d.Model, d.MAC, d.EncryptType, d.Answers)
+				if d.Interface != "" {
+					fmt.Printf(", via %s", d.Interface)
+				}
+				fmt.Println()
 			}
 		}
 		return
 	}
 
 	// Need credentials for full operation
-	if *username == "" || *password == "" {
-		fmt.Fprintln(os.Stderr, "Error: username and password required")
-		fmt.Fprintln(os.Stderr, "Provide via -username/-password flags or TAPO_USERNAME/TAPO_PASSWORD env vars")
-		os.Exit(1)
-	}
-
-	client := tapo.NewClient(*username, *password)
+	client := newClient(*username, *password, *credFile, cfg)
+	devCache := loadDeviceCache(*cachePath)
 
 	// Determine which devices to query
-	var deviceIPs []string
-
-	if *ip != "" {
-		deviceIPs = []string{*ip}
-	} else if *all {
+	deviceIPs := []string{*ip}
+	if *ip == "" {
 		if mode != modeJSON {
-			fmt.Println("Discovering devices...")
+			fmt.Println("Finding devices...")
 		}
-		devices, err := tapo.DiscoverWithTimeout(ctx, *timeout)
+		var err error
+		deviceIPs, err = resolveDeviceIPs(ctx, "", *all, disc, devCache, *refresh)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Discovery failed: %v\n", err)
 			os.Exit(1)
 		}
-		if len(devices) == 0 {
+		if len(deviceIPs) == 0 {
 			fmt.Println("No devices found")
 			os.Exit(0)
 		}
-		if mode != modeJSON {
-			fmt.Printf("Found %d device(s)\n\n", len(devices))
-		}
-		for _, d := range devices {
-			deviceIPs = append(deviceIPs, d.IP)
-		}
-	} else {
-		if mode != modeJSON {
-			fmt.Println("Discovering devices...")
+		if *all && mode != modeJSON {
+			fmt.Printf("Found %d device(s)\n\n", len(deviceIPs))
 		}
-		device, err := tapo.DiscoverFirst(ctx)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Discovery failed: %v\n", err)
-			os.Exit(1)
-		}
-		deviceIPs = []string{device.IP}
 	}
+	registerDevices(client, cfg, devCache)
 
 	// Control mode - turn device on/off
 	if *turnOn || *turnOff {
 		if len(deviceIPs) != 1 {
-			fmt.Fprintln(os.Stderr, "Error: on/off control requires exactly one device (use -ip flag)")
+			fmt.Fprintln(os.Stderr, "Error: on/off control requires exactly one device (use -ip flag, or p110 on|off <target>...)")
 			os.Exit(1)
 		}
 
@@ -212,7 +221,7 @@ func main() {
 			continue
 		}
 
-		data := queryDevice(device, mode, *rate, *currency)
+		data := queryDevice(device, mode, prices)
 		allData[deviceIP] = data
 
 		if mode != modeJSON && len(deviceIPs) > 1 && i < len(deviceIPs)-1 {
@@ -232,11 +241,7 @@ func main() {
 	}
 }
 
-func runDaemon(username, password, ip string, all bool, dbPath string, interval, timeout time.Duration) {
-	if username == "" || password == "" {
-		log.Fatal("Error: username and password required for daemon mode")
-	}
-
+func runDaemon(client *tapo.Client, ip string, all bool, dbPath, listen string, cfg *config.Config, interval time.Duration, disc *tapo.Discoverer, devCache *cache.Cache, dryRun bool) {
 	// Open database
 	db, err := store.Open(dbPath)
 	if err != nil {
@@ -250,41 +255,68 @@ func runDaemon(username, password, ip string, all bool, dbPath string, interval,
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	client := tapo.NewClient(username, password)
+	// Discover devices once at startup. The daemon starts rarely and must
+	// not miss new devices, so it doesn't trust the cache, but it does
+	// refresh it for other commands.
+	ctx, cancel := context.WithTimeout(context.Background(), disc.Timeout+time.Second)
+	deviceIPs, err := resolveDeviceIPs(ctx, ip, all, disc, devCache, true)
+	cancel()
+	if err != nil {
+		log.Fatalf("Discovery failed: %v", err)
+	}
+
+	if len(deviceIPs) == 0 {
+		log.Fatal("No devices found")
+	}
+	registerDevices(client, cfg, devCache)
+
+	log.Printf("Monitoring %d device(s): %v", len(deviceIPs), deviceIPs)
+
+	// Live power stream
+	hub := stream.NewHub()
+	if listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/stream", stream.NewHandler(hub, db))
+		server := &http.Server{Addr: listen, Handler: mux}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Stream server failed: %v", err)
+			}
+		}()
+		defer server.Close()
+		log.Printf("Streaming live readings on http://%s/stream", listen)
+	}
 
-	// Discover devices once at startup
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	var deviceIPs []string
+	p := &poller{client: client, db: db, hub: hub, cfg: cfg, snapshots: make(map[string]deviceSnapshot)}
 
-	if ip != "" {
-		deviceIPs = []string{ip}
-	} else if all {
-		devices, err := tapo.DiscoverWithTimeout(ctx, timeout)
+	// Alert rules
+	if len(cfg.Alerts.Rules) > 0 {
+		notifiers, err := alert.NewNotifiers(cfg.Alerts.Notifiers)
 		if err != nil {
-			cancel()
-			log.Fatalf("Discovery failed: %v", err)
-		}
-		for _, d := range devices {
-			deviceIPs = append(deviceIPs, d.IP)
+			log.Fatalf("Invalid alert config: %v", err)
 		}
-	} else {
-		device, err := tapo.DiscoverFirst(ctx)
+		p.alerts, err = alert.NewEngine(cfg.Alerts.Rules, notifiers)
 		if err != nil {
-			cancel()
-			log.Fatalf("Discovery failed: %v", err)
+			log.Fatalf("Invalid alert config: %v", err)
 		}
-		deviceIPs = []string{device.IP}
+		log.Printf("Evaluating %d alert rule(s) with %d notifier(s)", len(cfg.Alerts.Rules), len(notifiers))
 	}
-	cancel()
 
-	if len(deviceIPs) == 0 {
-		log.Fatal("No devices found")
+	// Automation rules
+	if len(cfg.Automations) > 0 {
+		p.automation, err = automation.NewEngine(cfg.Automations, db, interval, dryRun)
+		if err != nil {
+			log.Fatalf("Invalid automation config: %v", err)
+		}
+		mode := ""
+		if dryRun {
+			mode = " (dry run)"
+		}
+		log.Printf("Evaluating %d automation rule(s)%s", len(cfg.Automations), mode)
 	}
 
-	log.Printf("Monitoring %d device(s): %v", len(deviceIPs), deviceIPs)
-
 	// Initial poll
-	pollDevices(client, db, deviceIPs)
+	p.poll(deviceIPs)
 
 	// Start ticker
 	ticker := time.NewTicker(interval)
@@ -293,7 +325,7 @@ func runDaemon(username, password, ip string, all bool, dbPath string, interval,
 	for {
 		select {
 		case <-ticker.C:
-			pollDevices(client, db, deviceIPs)
+			p.poll(deviceIPs)
 		case sig := <-sigChan:
 			log.Printf("Received signal %v, shutting down...", sig)
 			printDBStats(db)
@@ -302,75 +334,263 @@ func runDaemon(username, password, ip string, all bool, dbPath string, interval,
 	}
 }
 
-func pollDevices(client *tapo.Client, db *store.Store, deviceIPs []string) {
+// poller holds the daemon's state between polls.
+type poller struct {
+	client *tapo.Client
+	db     *store.Store
+	hub    *stream.Hub
+	cfg    *config.Config
+	alerts *alert.Engine // nil when no alert rules are configured
+
+	automation *automation.Engine // nil when no automation rules are configured
+
+	snapshots map[string]deviceSnapshot // last state seen, by device key
+}
+
+// deviceName returns the config name for a device, falling back to its nickname.
+func (p *poller) deviceName(deviceIP, mac, nickname string) string {
+	if d := p.cfg.Device(deviceIP); d != nil {
+		return d.Name
+	}
+	if mac != "" {
+		if d := p.cfg.Device(mac); d != nil {
+			return d.Name
+		}
+	}
+	return nickname
+}
+
+// observe passes a poll result to the alert engine, if any.
+func (p *poller) observe(o alert.Observation) {
+	if p.alerts != nil {
+		p.alerts.Observe(o)
+	}
+}
+
+func (p *poller) poll(deviceIPs []string) {
 	now := time.Now()
-	dateStr := now.Format("2006-01-02")
+	db := p.db
 
 	for _, deviceIP := range deviceIPs {
-		device, err := client.Connect(deviceIP)
+		obs := alert.Observation{Time: now, DeviceIP: deviceIP, Name: p.deviceName(deviceIP, "", "")}
+
+		start := time.Now()
+		device, err := p.client.Connect(deviceIP)
 		if err != nil {
 			log.Printf("[%s] Connection failed: %v", deviceIP, err)
+			// Name the device as it was last seen, so rules naming it by
+			// MAC or nickname still see it go offline.
+			if prev, ok := p.snapshots[deviceIP]; ok {
+				obs.DeviceMAC = prev.mac
+				obs.Name = p.deviceName(deviceIP, prev.mac, prev.nickname)
+			}
+			p.recordOffline(deviceIP, now)
+			p.observe(obs)
 			continue
 		}
+		obs.Online = true
 
-		// Get device info for MAC
+		// Get device info for MAC, on/off state and health
 		info, err := device.GetDeviceInfo()
+		latency := time.Since(start)
 		mac := ""
+		deviceOn := false
+		var health *store.HealthRecord
 		if err == nil && info != nil {
 			mac = info.MAC
+			deviceOn = info.DeviceON
+			obs.DeviceMAC = mac
+			obs.Name = p.deviceName(deviceIP, mac, info.Nickname)
+			obs.DeviceOn = info.DeviceON
+			obs.OverHeated = info.OverHeated
+			p.recordSnapshot(deviceIP, snapshotFromInfo(info), now)
+			health = healthRecord(deviceIP, info, latency, now)
+		}
+		state := automation.State{
+			Time:      now,
+			DeviceIP:  deviceIP,
+			DeviceMAC: mac,
+			Name:      obs.Name,
+			DeviceOn:  deviceOn,
+		}
+		// Without device info, assume the device meters energy and try anyway.
+		caps := tapo.Capabilities{Energy: true}
+		if info != nil {
+			state.OnTime = time.Duration(info.OnTime) * time.Second
+			caps = tapo.DetectCapabilities(info.Model, info.Type)
 		}
 
 		// Get and store current power
-		power, err := device.GetCurrentPower()
-		if err != nil {
-			log.Printf("[%s] Failed to get power: %v", deviceIP, err)
-		} else {
-			if err := db.InsertReading(deviceIP, mac, power.CurrentPower); err != nil {
+		var power *tapo.CurrentPower
+		if caps.Energy {
+			power, err = device.GetCurrentPower()
+			if err != nil {
+				log.Printf("[%s] Failed to get power: %v", deviceIP, err)
+			}
+		}
+		if power != nil {
+			obs.HavePower = true
+			obs.PowerW = float64(power.CurrentPower) / 1000.0
+			state.HavePower = true
+			state.PowerW = obs.PowerW
+
+			reading, err := db.InsertReading(deviceIP, mac, power.CurrentPower, deviceOn)
+			if err != nil {
 				log.Printf("[%s] Failed to store reading: %v", deviceIP, err)
 			} else {
 				log.Printf("[%s] Power: %.1f W", deviceIP, float64(power.CurrentPower)/1000.0)
+				event := stream.EventFromReading(*reading)
+				if health != nil {
+					health.ReadingID = reading.ID
+					event.Health = stream.HealthFromRecord(*health)
+				}
+				p.hub.Publish(event)
+			}
+		}
+		if health != nil {
+			if err := db.InsertHealth(*health); err != nil {
+				log.Printf("[%s] Failed to store health: %v", deviceIP, err)
 			}
 		}
+		p.observe(obs)
 
-		// Get and store hourly data
-		hourly, err := device.GetEnergyData(tapo.EnergyDataHourly, now)
-		if err != nil {
-			log.Printf("[%s] Failed to get hourly data: %v", deviceIP, err)
-		} else if hourly != nil {
-			for hour, wh := range hourly.Data {
-				if wh > 0 {
-					if err := db.InsertHourly(dateStr, hour, deviceIP, wh); err != nil {
-						log.Printf("[%s] Failed to store hourly: %v", deviceIP, err)
-					}
-				}
+		if caps.Energy {
+			if energyUsage := p.archive(device, deviceIP, now); energyUsage != nil {
+				state.HaveEnergy = true
+				state.TodayWh = energyUsage.TodayEnergy
 			}
 		}
 
-		// Get and store energy usage (for daily data)
-		energyUsage, err := device.GetEnergyUsage()
-		if err != nil {
-			log.Printf("[%s] Failed to get energy usage: %v", deviceIP, err)
-		} else if energyUsage != nil {
-			if err := db.InsertDaily(dateStr, deviceIP, energyUsage.TodayEnergy, energyUsage.TodayRuntime); err != nil {
-				log.Printf("[%s] Failed to store daily: %v", deviceIP, err)
+		if caps.Children {
+			p.pollChildren(device.Strip(), deviceIP, now)
+		}
+
+		// Automation needs the on/off state, so skip it if device info failed.
+		if p.automation != nil && info != nil {
+			p.automation.Evaluate(state, device)
+		}
+	}
+}
+
+// healthRecord captures the health fields of a device info reply.
+func healthRecord(deviceIP string, info *tapo.DeviceInfo, latency time.Duration, now time.Time) *store.HealthRecord {
+	return &store.HealthRecord{
+		Timestamp:       now,
+		DeviceIP:        deviceIP,
+		DeviceMAC:       info.MAC,
+		RSSI:            info.RSSI,
+		SignalLevel:     info.SignalLevel,
+		OnTimeS:         info.OnTime,
+		OverHeated:      info.OverHeated,
+		PowerProtection: info.PowerProtectionStatus,
+		Firmware:        info.FirmwareVersion,
+		LatencyMS:       int(latency.Milliseconds()),
+	}
+}
+
+// meter is the energy surface shared by plugs and strip outlets.
+type meter interface {
+	GetCurrentPower() (*tapo.CurrentPower, error)
+	GetEnergyUsage() (*tapo.EnergyUsage, error)
+	GetEnergyData(interval tapo.EnergyDataInterval, t time.Time) (*tapo.EnergyData, error)
+}
+
+// archive stores a device's hourly, daily and monthly energy before the
+// device forgets it, and returns today's energy usage if it could be read.
+func (p *poller) archive(m meter, deviceKey string, now time.Time) *tapo.EnergyUsage {
+	dateStr := now.Format("2006-01-02")
+	db := p.db
+
+	// Get and store hourly data
+	hourly, err := m.GetEnergyData(tapo.EnergyDataHourly, now)
+	if errors.Is(err, tapo.ErrNotSupported) {
+		// Kasa plugs keep no hourly history
+	} else if err != nil {
+		log.Printf("[%s] Failed to get hourly data: %v", deviceKey, err)
+	} else if hourly != nil {
+		for hour, wh := range hourly.Data {
+			if wh > 0 {
+				if err := db.InsertHourly(dateStr, hour, deviceKey, wh); err != nil {
+					log.Printf("[%s] Failed to store hourly: %v", deviceKey, err)
+				}
 			}
 		}
+	}
 
-		// Get and store monthly data
-		monthly, err := device.GetEnergyData(tapo.EnergyDataMonthly, now)
-		if err != nil {
-			log.Printf("[%s] Failed to get monthly data: %v", deviceIP, err)
-		} else if monthly != nil {
-			year := now.Year()
-			for month, wh := range monthly.Data {
-				if wh > 0 {
-					if err := db.InsertMonthly(year, month+1, deviceIP, wh); err != nil {
-						log.Printf("[%s] Failed to store monthly: %v", deviceIP, err)
-					}
+	// Get and store energy usage (for daily data)
+	energyUsage, err := m.GetEnergyUsage()
+	if err != nil {
+		log.Printf("[%s] Failed to get energy usage: %v", deviceKey, err)
+	} else if energyUsage != nil {
+		if err := db.InsertDaily(dateStr, deviceKey, energyUsage.TodayEnergy, energyUsage.TodayRuntime); err != nil {
+			log.Printf("[%s] Failed to store daily: %v", deviceKey, err)
+		}
+	}
+
+	// Get and store monthly data
+	monthly, err := m.GetEnergyData(tapo.EnergyDataMonthly, now)
+	if err != nil {
+		log.Printf("[%s] Failed to get monthly data: %v", deviceKey, err)
+	} else if monthly != nil {
+		year := now.Year()
+		for month, wh := range monthly.Data {
+			if wh > 0 {
+				if err := db.InsertMonthly(year, month+1, deviceKey, wh); err != nil {
+					log.Printf("[%s] Failed to store monthly: %v", deviceKey, err)
 				}
 			}
 		}
 	}
+
+	return energyUsage
+}
+
+// pollChildren records each outlet of a power strip as its own device, keyed
+// by tapo.ChildKey. Outlets without metering only update child_devices.
+func (p *poller) pollChildren(strip *tapo.Strip, stripIP string, now time.Time) {
+	children, err := strip.Children()
+	if err != nil {
+		log.Printf("[%s] Failed to list outlets: %v", stripIP, err)
+		return
+	}
+
+	for _, c := range children {
+		key := tapo.ChildKey(stripIP, c.Position)
+		err := p.db.UpsertChildDevice(store.ChildDevice{
+			Key:       key,
+			ParentIP:  stripIP,
+			DeviceID:  c.DeviceID,
+			Position:  c.Position,
+			Nickname:  c.Nickname,
+			Model:     c.Model,
+			UpdatedAt: now,
+		})
+		if err != nil {
+			log.Printf("[%s] Failed to store outlet: %v", key, err)
+		}
+		p.recordSnapshot(key, snapshotFromChild(c), now)
+
+		if !c.Capabilities().Energy {
+			continue
+		}
+
+		child := strip.Child(c.DeviceID)
+		power, err := child.GetCurrentPower()
+		if err != nil {
+			log.Printf("[%s] Failed to get power: %v", key, err)
+			continue
+		}
+
+		reading, err := p.db.InsertReading(key, "", power.CurrentPower, c.DeviceON)
+		if err != nil {
+			log.Printf("[%s] Failed to store reading: %v", key, err)
+		} else {
+			log.Printf("[%s] Power: %.1f W", key, float64(power.CurrentPower)/1000.0)
+			p.hub.Publish(stream.EventFromReading(*reading))
+		}
+
+		p.archive(child, key, now)
+	}
 }
 
 func printDBStats(db *store.Store) {
@@ -379,7 +599,7 @@ func printDBStats(db *store.Store) {
 		readings, hourly, daily, monthly)
 }
 
-func showHistory(dbPath, deviceIP string, days int, rate float64, currency string) {
+func showHistory(dbPath, deviceIP string, days int, prices pricing) {
 	db, err := store.Open(dbPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
@@ -454,6 +674,25 @@ func showHistory(dbPath, deviceIP string, days int, rate float64, currency strin
 
 	fmt.Println(strings.Repeat("─", 70))
 
+	fmt.Println("Device Health (last 24h):")
+	healthRecords, err := db.GetHealthRange(deviceIP, endDate.Add(-24*time.Hour), endDate)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "  Error: %v\n", err)
+	} else if len(healthRecords) == 0 {
+		fmt.Println("  No health data found")
+	} else {
+		events, _ := db.GetEventsSince(deviceIP, "", endDate.Add(-24*time.Hour))
+		reboots := 0
+		for _, e := range events {
+			if e.Kind == eventReboot {
+				reboots++
+			}
+		}
+		printHealthSummary(healthRecords, reboots)
+	}
+
+	fmt.Println(strings.Repeat("─", 70))
+
 	// Show hourly data for today
 	fmt.Println("Hourly Data (today):")
 	todayStr := endDate.Format("2006-01-02")
@@ -475,6 +714,21 @@ func showHistory(dbPath, deviceIP string, days int, rate float64, currency strin
 
 	fmt.Println(strings.Repeat("─", 70))
 
+	// Price archived usage from the start of the earliest month shown, so
+	// monthly slab tiers accumulate correctly.
+	var costs tariff.Breakdown
+	if prices.tariff != nil {
+		priceStart := time.Date(endDate.Year()-1, 1, 1, 0, 0, 0, 0, time.Local)
+		if monthStart := time.Date(startDate.Year(), startDate.Month(), 1, 0, 0, 0, 0, time.Local); monthStart.Before(priceStart) {
+			priceStart = monthStart
+		}
+		costs, err = historyCosts(db, deviceIP, priceStart, endDate, prices.tariff)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  Error pricing usage: %v\n", err)
+			prices.tariff = nil
+		}
+	}
+
 	// Show daily data
 	fmt.Printf("Daily Data (last %d days):\n", days)
 	dailyRecords, err := db.GetDailyRange(deviceIP, startStr, endStr)
@@ -484,19 +738,22 @@ func showHistory(dbPath, deviceIP string, days int, rate float64, currency strin
 		fmt.Println("  No daily data found")
 	} else {
 		var totalEnergy int
+		var totalCost float64
 		fmt.Println("  Date        Energy    Runtime")
 		for _, r := range dailyRecords {
 			kwh := float64(r.EnergyWh) / 1000.0
 			totalEnergy += r.EnergyWh
 			cost := ""
-			if rate > 0 {
-				cost = fmt.Sprintf(" %s%.1f", currency, kwh*rate)
+			if prices.tariff != nil {
+				dayCost := costs.ByDay[r.Date]
+				totalCost += dayCost
+				cost = " " + prices.format(dayCost, 1)
 			}
 			fmt.Printf("  %s  %6.2f kWh  %4dmin%s\n", r.Date, kwh, r.RuntimeMin, cost)
 		}
 		fmt.Printf("  Total: %.2f kWh", float64(totalEnergy)/1000.0)
-		if rate > 0 {
-			fmt.Printf(" (%s%.0f)", currency, float64(totalEnergy)/1000.0*rate)
+		if prices.tariff != nil {
+			fmt.Printf(" (%s)", prices.format(totalCost, 0))
 		}
 		fmt.Println()
 	}
@@ -513,85 +770,149 @@ func showHistory(dbPath, deviceIP string, days int, rate float64, currency strin
 	} else {
 		months := []string{"", "Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
 		var totalEnergy int
+		var totalCost float64
 		for _, r := range monthlyRecords {
 			kwh := float64(r.EnergyWh) / 1000.0
 			totalEnergy += r.EnergyWh
 			cost := ""
-			if rate > 0 {
-				cost = fmt.Sprintf(" %s%.0f", currency, kwh*rate)
+			if prices.tariff != nil {
+				monthCost := costs.ByMonth[fmt.Sprintf("%04d-%02d", r.Year, r.Month)]
+				totalCost += monthCost
+				cost = " " + prices.format(monthCost, 0)
 			}
 			fmt.Printf("  %d %s: %6.2f kWh%s\n", r.Year, months[r.Month], kwh, cost)
 		}
 		fmt.Printf("  Total archived: %.2f kWh", float64(totalEnergy)/1000.0)
-		if rate > 0 {
-			fmt.Printf(" (%s%.0f)", currency, float64(totalEnergy)/1000.0*rate)
+		if prices.tariff != nil {
+			fmt.Printf(" (%s)", prices.format(totalCost, 0))
 		}
 		fmt.Println()
 	}
 }
 
-func queryDevice(device *tapo.P110, mode outputMode, rate float64, currency string) map[string]interface{} {
+// printHealthSummary summarises Wi-Fi signal, poll latency, reboots and
+// protection trips over a set of polls.
+func printHealthSummary(records []store.HealthRecord, reboots int) {
+	var rssiTotal, latencyTotal int
+	minRSSI, maxLatency := records[0].RSSI, records[0].LatencyMS
+	minLevel := records[0].SignalLevel
+	overheated, protected := 0, 0
+	for _, h := range records {
+		rssiTotal += h.RSSI
+		latencyTotal += h.LatencyMS
+		minRSSI = min(minRSSI, h.RSSI)
+		minLevel = min(minLevel, h.SignalLevel)
+		maxLatency = max(maxLatency, h.LatencyMS)
+		if h.OverHeated {
+			overheated++
+		}
+		if h.PowerProtection != "" && h.PowerProtection != "normal" {
+			protected++
+		}
+	}
+	n := len(records)
+	last := records[n-1]
+
+	fmt.Printf("  %d polls | RSSI avg %d dBm, worst %d dBm | Signal level worst %d/3\n",
+		n, rssiTotal/n, minRSSI, minLevel)
+	fmt.Printf("  Poll latency avg %d ms, max %d ms | On for %s\n",
+		latencyTotal/n, maxLatency, formatOnTime(last.OnTimeS))
+	fmt.Printf("  Reboots: %d | Overheated polls: %d | Power protection tripped: %d\n",
+		reboots, overheated, protected)
+	if last.Firmware != "" {
+		fmt.Printf("  Firmware: %s\n", last.Firmware)
+	}
+}
+
+func queryDevice(device *tapo.P110, mode outputMode, prices pricing) map[string]interface{} {
 	data := make(map[string]interface{})
 
 	// Device info
+	caps := tapo.Capabilities{Energy: true}
 	info, err := device.GetDeviceInfo()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to get device info: %v\n", err)
 	} else {
 		data["device_info"] = info
+		caps = tapo.DetectCapabilities(info.Model, info.Type)
+		data["capabilities"] = caps
 	}
 
 	// Device usage
 	usage, err := device.GetDeviceUsage()
-	if err != nil {
+	if errors.Is(err, tapo.ErrNotSupported) {
+		// not kept by Kasa plugs
+	} else if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to get device usage: %v\n", err)
 	} else {
 		data["device_usage"] = usage
 	}
 
-	// Current power
-	power, err := device.GetCurrentPower()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to get current power: %v\n", err)
-	} else {
-		data["current_power"] = power
-	}
+	// Energy, for devices that meter it
+	var power *tapo.CurrentPower
+	var energyUsage *tapo.EnergyUsage
+	var hourlyData, dailyData, monthlyData *tapo.EnergyData
+	if caps.Energy {
+		// Current power
+		power, err = device.GetCurrentPower()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get current power: %v\n", err)
+		} else {
+			data["current_power"] = power
+		}
 
-	// Energy usage
-	energyUsage, err := device.GetEnergyUsage()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to get energy usage: %v\n", err)
-	} else {
-		data["energy_usage"] = energyUsage
-	}
+		// Energy usage
+		energyUsage, err = device.GetEnergyUsage()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get energy usage: %v\n", err)
+		} else {
+			data["energy_usage"] = energyUsage
+		}
 
-	// Energy data
-	today := time.Now()
-	hourlyData, err := device.GetEnergyData(tapo.EnergyDataHourly, today)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to get hourly energy data: %v\n", err)
-	} else {
-		data["energy_data_hourly"] = hourlyData
-	}
+		// Energy data
+		today := time.Now()
+		hourlyData, err = device.GetEnergyData(tapo.EnergyDataHourly, today)
+		if errors.Is(err, tapo.ErrNotSupported) {
+			// not kept by Kasa plugs
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get hourly energy data: %v\n", err)
+		} else {
+			data["energy_data_hourly"] = hourlyData
+		}
 
-	dailyData, err := device.GetEnergyData(tapo.EnergyDataDaily, today)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to get daily energy data: %v\n", err)
-	} else {
-		data["energy_data_daily"] = dailyData
+		dailyData, err = device.GetEnergyData(tapo.EnergyDataDaily, today)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get daily energy data: %v\n", err)
+		} else {
+			data["energy_data_daily"] = dailyData
+		}
+
+		monthlyData, err = device.GetEnergyData(tapo.EnergyDataMonthly, today)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get monthly energy data: %v\n", err)
+		} else {
+			data["energy_data_monthly"] = monthlyData
+		}
 	}
 
-	monthlyData, err := device.GetEnergyData(tapo.EnergyDataMonthly, today)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to get monthly energy data: %v\n", err)
-	} else {
-		data["energy_data_monthly"] = monthlyData
+	// Strip outlets
+	var children []tapo.ChildInfo
+	if caps.Children {
+		children, err = device.Strip().Children()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list outlets: %v\n", err)
+		} else {
+			data["children"] = children
+		}
 	}
 
 	// Output based on mode
 	switch mode {
 	case modeSummary:
-		printSummary(info, usage, power, energyUsage, hourlyData, dailyData, monthlyData, rate, currency)
+		printSummary(info, usage, power, energyUsage, hourlyData, dailyData, monthlyData, prices)
+		for _, c := range children {
+			fmt.Printf("   Outlet %d: %s [%s] (%s)\n", c.Position, c.Nickname, onOff(c.DeviceON), tapo.ChildKey(device.IP(), c.Position))
+		}
 	case modeRaw:
 		printRaw(data)
 	}
@@ -600,7 +921,7 @@ func queryDevice(device *tapo.P110, mode outputMode, rate float64, currency stri
 }
 
 func printSummary(info *tapo.DeviceInfo, usage *tapo.DeviceUsage, power *tapo.CurrentPower,
-	energyUsage *tapo.EnergyUsage, hourly, daily, monthly *tapo.EnergyData, rate float64, currency string) {
+	energyUsage *tapo.EnergyUsage, hourly, daily, monthly *tapo.EnergyData, prices pricing) {
 
 	// Device header
 	if info != nil {
@@ -613,6 +934,17 @@ func printSummary(info *tapo.DeviceInfo, usage *tapo.DeviceUsage, power *tapo.Cu
 			status = "ON"
 		}
 		fmt.Printf("%s (%s) [%s] Signal: %ddBm\n", name, info.Model, status, info.RSSI)
+		if caps := tapo.DetectCapabilities(info.Model, info.Type); caps.Brightness {
+			fmt.Printf("Brightness: %d%%", info.Brightness)
+			if caps.Color {
+				if info.ColorTemp > 0 {
+					fmt.Printf("   Colour: %d K", info.ColorTemp)
+				} else {
+					fmt.Printf("   Colour: hue %d, saturation %d%%", info.Hue, info.Saturation)
+				}
+			}
+			fmt.Println()
+		}
 	}
 	fmt.Println(strings.Repeat("─", 70))
 
@@ -626,10 +958,11 @@ func printSummary(info *tapo.DeviceInfo, usage *tapo.DeviceUsage, power *tapo.Cu
 		}
 		fmt.Println()
 
-		if rate > 0 && energyUsage != nil {
-			todayCost := float64(energyUsage.TodayEnergy) / 1000.0 * rate
-			monthCost := float64(energyUsage.MonthEnergy) / 1000.0 * rate
-			fmt.Printf("Cost:              Today: %s%.2f                    Month: %s%.2f\n", currency, todayCost, currency, monthCost)
+		if prices.tariff != nil && energyUsage != nil {
+			now := time.Now()
+			todayCost := prices.tariff.Cost(todayUsage(now, hourly, energyUsage)).Total
+			monthCost := prices.tariff.Cost(monthUsage(now, hourly, daily, energyUsage)).Total
+			fmt.Printf("Cost:              Today: %s                    Month: %s\n", prices.format(todayCost, 2), prices.format(monthCost, 2))
 		}
 	}
 
@@ -648,7 +981,7 @@ func printSummary(info *tapo.DeviceInfo, usage *tapo.DeviceUsage, power *tapo.Cu
 	// Monthly - horizontal bars
 	if monthly != nil && len(monthly.Data) > 0 {
 		fmt.Println(strings.Repeat("─", 70))
-		printMonthlyBars(monthly.Data, rate, currency)
+		printMonthlyBars(monthly.Data, prices)
 	}
 }
 
@@ -742,7 +1075,7 @@ func printDailyWeekly(data []int) {
 		float64(total)/1000.0, avg, peak, peakDay+1)
 }
 
-func printMonthlyBars(data []int, rate float64, currency string) {
+func printMonthlyBars(data []int, prices pricing) {
 	months := []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
 
 	n := len(data)
@@ -750,6 +1083,22 @@ func printMonthlyBars(data []int, rate float64, currency string) {
 		n = 12
 	}
 
+	// Only monthly totals are available here; each is spread over its month
+	// (up to now for the current month) to apply time-of-use and tier rates.
+	var costs tariff.Breakdown
+	if prices.tariff != nil {
+		now := time.Now()
+		var usage []tariff.Usage
+		for i := 0; i < n; i++ {
+			u := tariff.Month(now.Year(), time.Month(i+1), data[i], now.Location())
+			if u.End.After(now) {
+				u.End = now
+			}
+			usage = append(usage, u)
+		}
+		costs = prices.tariff.Cost(usage)
+	}
+
 	// Find max for bar scaling
 	max := 1
 	for i := 0; i < n; i++ {
@@ -767,9 +1116,9 @@ func printMonthlyBars(data []int, rate float64, currency string) {
 		}
 		bar := strings.Repeat("█", barLen) + strings.Repeat("░", 30-barLen)
 
-		if rate > 0 {
-			cost := kwh * rate
-			fmt.Printf("  %s %6.2f kWh %s %s%.0f\n", months[i], kwh, bar, currency, cost)
+		if prices.tariff != nil {
+			cost := costs.ByMonth[fmt.Sprintf("%04d-%02d", time.Now().Year(), i+1)]
+			fmt.Printf("  %s %6.2f kWh %s %s\n", months[i], kwh, bar, prices.format(cost, 0))
 		} else {
 			fmt.Printf("  %s %6.2f kWh %s\n", months[i], kwh, bar)
 		}
@@ -778,8 +1127,8 @@ func printMonthlyBars(data []int, rate float64, currency string) {
 	// Year total
 	total := sum(data[:n])
 	fmt.Printf("  Year: %6.2f kWh", float64(total)/1000.0)
-	if rate > 0 {
-		fmt.Printf(" (%s%.0f)", currency, float64(total)/1000.0*rate)
+	if prices.tariff != nil {
+		fmt.Printf(" (%s)", prices.format(costs.Total, 0))
 	}
 	fmt.Println()
 }
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/abhishek/p110/internal/tapo"
+)
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ruleFlags holds the flags shared by `p110 timer` and `p110 schedule`.
+type ruleFlags struct {
+	conn       *connFlags
+	id         *string
+	action     *string
+	disable    *bool
+	jsonOutput *bool
+}
+
+// addRuleFlags registers the shared rule flags on a subcommand's flag set.
+func addRuleFlags(fs *flag.FlagSet) *ruleFlags {
+	return &ruleFlags{
+		conn:       addConnFlags(fs),
+		id:         fs.String("id", "", "Rule ID (for edit and delete)"),
+		action:     fs.String("action", "off", "State to switch to: on or off"),
+		disable:    fs.Bool("disable", false, "Create or leave the rule disabled"),
+		jsonOutput: fs.Bool("json", false, "Output in JSON format"),
+	}
+}
+
+// parseRuleArgs splits `p110 <cmd> <verb> [flags]` into the verb and flags.
+func parseRuleArgs(name string, args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "list", args
+	}
+	switch args[0] {
+	case "list", "add", "edit", "delete":
+		return args[0], args[1:]
+	}
+	fmt.Fprintf(os.Stderr, "Usage: p110 %s [list|add|edit|delete] [flags]\n", name)
+	os.Exit(1)
+	return "", nil
+}
+
+// devices connects to the selected plugs. edit and delete address a rule ID,
+// which only means something on one device.
+func (f *ruleFlags) devices(verb string) []*tapo.P110 {
+	client := f.conn.client()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	deviceIPs := f.conn.deviceIPs(ctx)
+	cancel()
+
+	if (verb == "edit" || verb == "delete") && len(deviceIPs) != 1 {
+		fmt.Fprintf(os.Stderr, "Error: %s needs exactly one device (use -ip)\n", verb)
+		os.Exit(1)
+	}
+	if (verb == "edit" || verb == "delete") && *f.id == "" {
+		fmt.Fprintf(os.Stderr, "Error: %s requires -id\n", verb)
+		os.Exit(1)
+	}
+
+	var devices []*tapo.P110
+	for _, ip := range deviceIPs {
+		device, err := client.Connect(ip)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] Connection failed: %v\n", ip, err)
+			continue
+		}
+		devices = append(devices, device)
+	}
+	if len(devices) == 0 {
+		os.Exit(1)
+	}
+	return devices
+}
+
+// desiredState parses -action.
+func (f *ruleFlags) desiredState() tapo.DesiredStates {
+	switch strings.ToLower(*f.action) {
+	case "on":
+		return tapo.DesiredStates{On: true}
+	case "off":
+		return tapo.DesiredStates{On: false}
+	}
+	fmt.Fprintf(os.Stderr, "Error: -action must be on or off, not %q\n", *f.action)
+	os.Exit(1)
+	return tapo.DesiredStates{}
+}
+
+// runTimer implements `p110 timer`: on-device countdown timers.
+func runTimer(args []string) {
+	verb, args := parseRuleArgs("timer", args)
+
+	fs := flag.NewFlagSet("timer", flag.ExitOnError)
+	rf := addRuleFlags(fs)
+	delay := fs.Duration("delay", 0, "Countdown length (e.g. 30m)")
+	fs.Parse(args)
+
+	if verb == "add" && *delay <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: add requires -delay")
+		os.Exit(1)
+	}
+
+	for _, device := range rf.devices(verb) {
+		ip := device.IP()
+		var err error
+		switch verb {
+		case "list":
+			var list *tapo.RuleList[tapo.CountdownRule]
+			if list, err = device.GetCountdownRules(); err == nil {
+				printRules(ip, rf, list.Rules, len(list.Rules), list.MaxCount, formatCountdown)
+			}
+		case "add":
+			var id string
+			id, err = device.AddCountdownRule(tapo.CountdownRule{
+				Enable:        !*rf.disable,
+				Delay:         int(delay.Seconds()),
+				DesiredStates: rf.desiredState(),
+			})
+			if err == nil {
+				fmt.Printf("[%s] Added timer %s\n", ip, id)
+			}
+		case "edit":
+			var list *tapo.RuleList[tapo.CountdownRule]
+			if list, err = device.GetCountdownRules(); err != nil {
+				break
+			}
+			rule := findRule(list.Rules, *rf.id, func(r tapo.CountdownRule) string { return r.ID })
+			fs.Visit(func(f *flag.Flag) {
+				switch f.Name {
+				case "delay":
+					rule.Delay = int(delay.Seconds())
+					rule.Remain = 0
+				case "action":
+					rule.DesiredStates = rf.desiredState()
+				case "disable":
+					rule.Enable = !*rf.disable
+				}
+			})
+			if err = device.EditCountdownRule(*rule); err == nil {
+				fmt.Printf("[%s] Updated timer %s\n", ip, rule.ID)
+			}
+		case "delete":
+			if err = device.RemoveCountdownRule(*rf.id); err == nil {
+				fmt.Printf("[%s] Deleted timer %s\n", ip, *rf.id)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] Failed to %s timer: %v\n", ip, verb, err)
+		}
+	}
+}
+
+// runSchedule implements `p110 schedule`: on-device schedules, or away-mode
+// rules with -away.
+func runSchedule(args []string) {
+	verb, args := parseRuleArgs("schedule", args)
+
+	fs := flag.NewFlagSet("schedule", flag.ExitOnError)
+	rf := addRuleFlags(fs)
+	away := fs.Bool("away", false, "Manage away-mode rules instead of schedules")
+	at := fs.String("at", "", "Start time: HH:MM, sunrise or sunset with optional offset (e.g. sunset-30m)")
+	until := fs.String("until", "", "End time for away mode, in the same format as -at")
+	days := fs.String("days", "daily", "Days to repeat on: daily, weekdays, weekends or a list like mon,wed,fri")
+	once := fs.String("once", "", "Run once on this date (YYYY-MM-DD) instead of repeating")
+	fs.Parse(args)
+
+	if verb == "add" && (*at == "" || (*away && *until == "")) {
+		fmt.Fprintln(os.Stderr, "Error: add requires -at (and -until with -away)")
+		os.Exit(1)
+	}
+
+	// when applies the timing flags that were given to a rule.
+	when := func(t *tapo.RuleTime) {
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "at":
+				t.StartType, t.StartMin, t.TimeOffset = mustParseTimeSpec(*at)
+			case "until":
+				var offset int
+				t.EndType, t.EndMin, offset = mustParseTimeSpec(*until)
+				if offset != 0 {
+					fmt.Fprintln(os.Stderr, "Error: -until does not support sunrise/sunset offsets")
+					os.Exit(1)
+				}
+			case "days":
+				t.Mode, t.WeekDays = tapo.ModeRepeat, mustParseDays(*days)
+			case "once":
+				date, err := time.ParseInLocation("2006-01-02", *once, time.Local)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: invalid -once date %q\n", *once)
+					os.Exit(1)
+				}
+				t.Mode, t.WeekDays = tapo.ModeOnce, 0
+				t.Year, t.Month, t.Day = date.Year(), int(date.Month()), date.Day()
+			}
+		})
+		if t.Mode == "" {
+			t.Mode, t.WeekDays = tapo.ModeRepeat, mustParseDays(*days)
+		}
+	}
+
+	for _, device := range rf.devices(verb) {
+		ip := device.IP()
+		var err error
+		if *away {
+			err = awayRule(device, verb, rf, fs, when)
+		} else {
+			err = scheduleRule(device, verb, rf, fs, when)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] Failed to %s rule: %v\n", ip, verb, err)
+		}
+	}
+}
+
+// whenFunc applies the -at/-until/-days/-once flags to a rule.
+type whenFunc func(t *tapo.RuleTime)
+
+// scheduleRule carries out one `p110 schedule` verb on a device.
+func scheduleRule(device *tapo.P110, verb string, rf *ruleFlags, fs *flag.FlagSet, when whenFunc) error {
+	ip := device.IP()
+
+	switch verb {
+	case "list":
+		list, err := device.GetScheduleRules()
+		if err != nil {
+			return err
+		}
+		printRules(ip, rf, list.Rules, len(list.Rules), list.MaxCount, formatSchedule)
+	case "add", "edit":
+		rule := &tapo.ScheduleRule{Enable: true, RuleTime: tapo.RuleTime{EndType: tapo.TimeNone}, EndAction: "none"}
+		if verb == "edit" {
+			list, err := device.GetScheduleRules()
+			if err != nil {
+				return err
+			}
+			rule = findRule(list.Rules, *rf.id, func(r tapo.ScheduleRule) string { return r.ID })
+		}
+		when(&rule.RuleTime)
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "action":
+				rule.DesiredStates = rf.desiredState()
+			case "disable":
+				rule.Enable = !*rf.disable
+			}
+		})
+
+		if verb == "edit" {
+			if err := device.EditScheduleRule(*rule); err != nil {
+				return err
+			}
+			fmt.Printf("[%s] Updated schedule %s\n", ip, rule.ID)
+			return nil
+		}
+		id, err := device.AddScheduleRule(*rule)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("[%s] Added schedule %s\n", ip, id)
+	case "delete":
+		if err := device.RemoveScheduleRule(*rf.id); err != nil {
+			return err
+		}
+		fmt.Printf("[%s] Deleted schedule %s\n", ip, *rf.id)
+	}
+	return nil
+}
+
+// awayRule carries out one `p110 schedule -away` verb on a device.
+func awayRule(device *tapo.P110, verb string, rf *ruleFlags, fs *flag.FlagSet, when whenFunc) error {
+	ip := device.IP()
+
+	switch verb {
+	case "list":
+		list, err := device.GetAwayRules()
+		if err != nil {
+			return err
+		}
+		printRules(ip, rf, list.Rules, len(list.Rules), list.MaxCount, formatAway)
+	case "add", "edit":
+		rule := &tapo.AwayRule{Enable: true}
+		if verb == "edit" {
+			list, err := device.GetAwayRules()
+			if err != nil {
+				return err
+			}
+			rule = findRule(list.Rules, *rf.id, func(r tapo.AwayRule) string { return r.ID })
+		}
+		when(&rule.RuleTime)
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "disable" {
+				rule.Enable = !*rf.disable
+			}
+		})
+
+		if verb == "edit" {
+			if err := device.EditAwayRule(*rule); err != nil {
+				return err
+			}
+			fmt.Printf("[%s] Updated away rule %s\n", ip, rule.ID)
+			return nil
+		}
+		id, err := device.AddAwayRule(*rule)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("[%s] Added away rule %s\n", ip, id)
+	case "delete":
+		if err := device.RemoveAwayRule(*rf.id); err != nil {
+			return err
+		}
+		fmt.Printf("[%s] Deleted away rule %s\n", ip, *rf.id)
+	}
+	return nil
+}
+
+// findRule returns the rule with the given ID, exiting if there is none.
+func findRule[T any](rules []T, id string, idOf func(T) string) *T {
+	for i := range rules {
+		if idOf(rules[i]) == id {
+			return &rules[i]
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Error: no rule with id %q\n", id)
+	os.Exit(1)
+	return nil
+}
+
+// printRules lists one device's rules as text or JSON.
+func printRules[T any](ip string, rf *ruleFlags, rules []T, count, max int, format func(T) string) {
+	if *rf.jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(map[string]interface{}{"device": ip, "max_count": max, "rules": rules})
+		return
+	}
+
+	fmt.Printf("%s (%d of %d)\n", ip, count, max)
+	if len(rules) == 0 {
+		fmt.Println("  No rules")
+	}
+	for _, r := range rules {
+		fmt.Printf("  %s\n", format(r))
+	}
+}
+
+func enabledMark(enable bool) string {
+	if enable {
+		return ""
+	}
+	return " (disabled)"
+}
+
+func formatCountdown(r tapo.CountdownRule) string {
+	s := fmt.Sprintf("%-4s turn %s after %s", r.ID, onOff(r.DesiredStates.On), time.Duration(r.Delay)*time.Second)
+	if r.Enable && r.Remain > 0 {
+		s += fmt.Sprintf(", %s left", time.Duration(r.Remain)*time.Second)
+	}
+	return s + enabledMark(r.Enable)
+}
+
+func formatSchedule(r tapo.ScheduleRule) string {
+	return fmt.Sprintf("%-4s turn %s at %s %s%s", r.ID, onOff(r.DesiredStates.On),
+		formatTimeSpec(r.StartType, r.StartMin, r.TimeOffset), formatDays(r.RuleTime), enabledMark(r.Enable))
+}
+
+func formatAway(r tapo.AwayRule) string {
+	return fmt.Sprintf("%-4s away from %s to %s %s%s", r.ID,
+		formatTimeSpec(r.StartType, r.StartMin, r.TimeOffset), formatTimeSpec(r.EndType, r.EndMin, 0),
+		formatDays(r.RuleTime), enabledMark(r.Enable))
+}
+
+// mustParseTimeSpec parses "07:30", "sunrise", "sunset-30m" or "sunrise+1h"
+// into a schedule type, minutes after midnight and a sun offset in minutes.
+func mustParseTimeSpec(spec string) (string, int, int) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	for _, sun := range []string{tapo.TimeSunrise, tapo.TimeSunset} {
+		if !strings.HasPrefix(spec, sun) {
+			continue
+		}
+		rest := spec[len(sun):]
+		if rest == "" {
+			return sun, 0, 0
+		}
+		d, err := time.ParseDuration(rest)
+		if err != nil {
+			break
+		}
+		return sun, 0, int(d.Minutes())
+	}
+
+	t, err := time.Parse("15:04", spec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid time %q (use HH:MM, sunrise or sunset, e.g. sunset-30m)\n", spec)
+		os.Exit(1)
+	}
+	return tapo.TimeNormal, t.Hour()*60 + t.Minute(), 0
+}
+
+func formatTimeSpec(sType string, min, offset int) string {
+	switch sType {
+	case tapo.TimeSunrise, tapo.TimeSunset:
+		if offset == 0 {
+			return sType
+		}
+		sign := "+"
+		if offset < 0 {
+			sign, offset = "-", -offset
+		}
+		return sType + sign + (time.Duration(offset) * time.Minute).String()
+	case tapo.TimeNone, "":
+		return "-"
+	}
+	return fmt.Sprintf("%02d:%02d", min/60, min%60)
+}
+
+// mustParseDays parses -days into a weekday bitmask, bit 0 being Sunday.
+func mustParseDays(spec string) int {
+	switch strings.ToLower(spec) {
+	case "daily", "":
+		return 0x7f
+	case "weekdays":
+		return 0x3e
+	case "weekends":
+		return 0x41
+	}
+
+	mask := 0
+	for _, part := range strings.Split(strings.ToLower(spec), ",") {
+		found := false
+		for i, name := range weekdayNames {
+			if strings.HasPrefix(strings.TrimSpace(part), name) {
+				mask |= 1 << i
+				found = true
+			}
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "Error: invalid day %q\n", part)
+			os.Exit(1)
+		}
+	}
+	return mask
+}
+
+func formatDays(t tapo.RuleTime) string {
+	if t.Mode == tapo.ModeOnce {
+		return fmt.Sprintf("on %04d-%02d-%02d", t.Year, t.Month, t.Day)
+	}
+	mask := t.WeekDays
+	switch mask {
+	case 0x7f:
+		return "daily"
+	case 0x3e:
+		return "on weekdays"
+	case 0x41:
+		return "on weekends"
+	}
+	var names []string
+	for i, name := range weekdayNames {
+		if mask&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return "on " + strings.Join(names, ",")
+}
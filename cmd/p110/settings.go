@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhishek/p110/internal/tapo"
+)
+
+// deviceSettings is one device's `p110 settings get` output. Settings the
+// device doesn't support are left out.
+type deviceSettings struct {
+	Device          string                `json:"device"`
+	Name            string                `json:"name,omitempty"`
+	LED             *tapo.LEDInfo         `json:"led,omitempty"`
+	DefaultState    *tapo.DefaultState    `json:"default_state,omitempty"`
+	PowerProtection *tapo.PowerProtection `json:"power_protection,omitempty"`
+	AutoOff         *tapo.AutoOff         `json:"auto_off,omitempty"`
+	ChildLock       *bool                 `json:"child_lock,omitempty"`
+}
+
+// runSettings implements `p110 settings get|set`.
+func runSettings(args []string) {
+	if len(args) == 0 || (args[0] != "get" && args[0] != "set") {
+		fmt.Fprintln(os.Stderr, "Usage: p110 settings get|set [flags]")
+		os.Exit(1)
+	}
+	verb, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("settings", flag.ExitOnError)
+	conn := addConnFlags(fs)
+	jsonOutput := fs.Bool("json", false, "Output in JSON format")
+	led := fs.String("led", "", "LED mode: always, never or night")
+	defaultState := fs.String("default", "", "State after a power outage: last, on or off")
+	protection := fs.String("protection", "", "Overload protection threshold in watts, or off")
+	autoOff := fs.String("auto-off", "", "Turn off this long after being switched on (e.g. 2h), or off")
+	childLock := fs.String("child-lock", "", "Lock the physical button: on or off")
+	fs.Parse(args)
+
+	client := conn.client()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	deviceIPs := conn.deviceIPs(ctx)
+	cancel()
+
+	if verb == "set" {
+		changes := settingsChanges(*led, *defaultState, *protection, *autoOff, *childLock)
+		if len(changes) == 0 {
+			fmt.Fprintln(os.Stderr, "Error: nothing to set (use -led, -default, -protection, -auto-off or -child-lock)")
+			os.Exit(1)
+		}
+
+		failed := false
+		for _, ip := range deviceIPs {
+			device, err := client.Connect(ip)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] Connection failed: %v\n", ip, err)
+				failed = true
+				continue
+			}
+			for _, c := range changes {
+				if err := c.apply(device); err != nil {
+					fmt.Fprintf(os.Stderr, "[%s] Failed to set %s: %v\n", ip, c.name, err)
+					failed = true
+				} else {
+					fmt.Printf("[%s] Set %s\n", ip, c.name)
+				}
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var results []deviceSettings
+	for _, ip := range deviceIPs {
+		device, err := client.Connect(ip)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] Connection failed: %v\n", ip, err)
+			continue
+		}
+		results = append(results, readSettings(device))
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return
+	}
+
+	for _, s := range results {
+		printSettings(s)
+	}
+}
+
+// settingChange is one setting to apply to every selected device.
+type settingChange struct {
+	name  string
+	apply func(device *tapo.P110) error
+}
+
+// settingsChanges parses the -set flags into changes, exiting on bad values.
+func settingsChanges(led, defaultState, protection, autoOff, childLock string) []settingChange {
+	var changes []settingChange
+	fail := func(flagName, value string) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -%s value %q\n", flagName, value)
+		os.Exit(1)
+	}
+
+	if led != "" {
+		rule := map[string]string{"always": tapo.LEDAlways, "on": tapo.LEDAlways, "never": tapo.LEDNever, "off": tapo.LEDNever, "night": tapo.LEDNight}[strings.ToLower(led)]
+		if rule == "" {
+			fail("led", led)
+		}
+		changes = append(changes, settingChange{"LED to " + led, func(d *tapo.P110) error {
+			info, err := d.GetLEDInfo()
+			if err != nil {
+				return err
+			}
+			info.Rule = rule
+			return d.SetLEDInfo(*info)
+		}})
+	}
+
+	if defaultState != "" {
+		var state tapo.DefaultState
+		switch strings.ToLower(defaultState) {
+		case "last":
+			state = tapo.DefaultState{Type: tapo.DefaultLastState}
+		case "on":
+			state = tapo.DefaultState{Type: tapo.DefaultCustom, State: tapo.DesiredStates{On: true}}
+		case "off":
+			state = tapo.DefaultState{Type: tapo.DefaultCustom, State: tapo.DesiredStates{On: false}}
+		default:
+			fail("default", defaultState)
+		}
+		changes = append(changes, settingChange{"default state to " + defaultState, func(d *tapo.P110) error {
+			return d.SetDefaultState(state)
+		}})
+	}
+
+	if protection != "" {
+		pp := tapo.PowerProtection{}
+		if strings.ToLower(protection) != "off" {
+			watts, err := strconv.Atoi(strings.TrimSuffix(strings.ToUpper(protection), "W"))
+			if err != nil || watts <= 0 {
+				fail("protection", protection)
+			}
+			pp = tapo.PowerProtection{Enabled: true, Watts: watts}
+		}
+		changes = append(changes, settingChange{"power protection to " + protection, func(d *tapo.P110) error {
+			pp := pp
+			if !pp.Enabled {
+				// Keep the device's threshold so re-enabling restores it.
+				if cur, err := d.GetPowerProtection(); err == nil {
+					pp.Watts = cur.Watts
+				}
+			}
+			return d.SetPowerProtection(pp)
+		}})
+	}
+
+	if autoOff != "" {
+		ao := tapo.AutoOff{}
+		if strings.ToLower(autoOff) != "off" {
+			d, err := time.ParseDuration(autoOff)
+			if err != nil || d < time.Minute {
+				fail("auto-off", autoOff)
+			}
+			ao = tapo.AutoOff{Enable: true, DelayMin: int(d.Minutes())}
+		}
+		changes = append(changes, settingChange{"auto-off to " + autoOff, func(d *tapo.P110) error {
+			ao := ao
+			if !ao.Enable {
+				if cur, err := d.GetAutoOff(); err == nil {
+					ao.DelayMin = cur.DelayMin
+				}
+			}
+			return d.SetAutoOff(ao)
+		}})
+	}
+
+	if childLock != "" {
+		var locked bool
+		switch strings.ToLower(childLock) {
+		case "on":
+			locked = true
+		case "off":
+		default:
+			fail("child-lock", childLock)
+		}
+		changes = append(changes, settingChange{"child lock to " + childLock, func(d *tapo.P110) error {
+			return d.SetChildLock(locked)
+		}})
+	}
+
+	return changes
+}
+
+// readSettings collects every setting the device supports.
+func readSettings(device *tapo.P110) deviceSettings {
+	s := deviceSettings{Device: device.IP()}
+	if info, err := device.GetDeviceInfo(); err == nil {
+		s.Name = info.Nickname
+	}
+	if led, err := device.GetLEDInfo(); err == nil {
+		s.LED = led
+	}
+	if ds, err := device.GetDefaultState(); err == nil && ds.Type != "" {
+		s.DefaultState = ds
+	}
+	if pp, err := device.GetPowerProtection(); err == nil {
+		s.PowerProtection = pp
+	}
+	if ao, err := device.GetAutoOff(); err == nil {
+		s.AutoOff = ao
+	}
+	if locked, err := device.GetChildLock(); err == nil {
+		s.ChildLock = &locked
+	}
+	return s
+}
+
+func printSettings(s deviceSettings) {
+	title := s.Device
+	if s.Name != "" {
+		title = fmt.Sprintf("%s (%s)", s.Name, s.Device)
+	}
+	fmt.Println(title)
+
+	unsupported := "not supported"
+	line := func(label, value string) {
+		fmt.Printf("  %-18s %s\n", label+":", value)
+	}
+
+	switch {
+	case s.LED == nil:
+		line("LED", unsupported)
+	case s.LED.Rule == tapo.LEDNight:
+		line("LED", "night mode")
+	default:
+		line("LED", s.LED.Rule)
+	}
+
+	switch {
+	case s.DefaultState == nil:
+		line("Default state", unsupported)
+	case s.DefaultState.Type == tapo.DefaultLastState:
+		line("Default state", "last state")
+	default:
+		line("Default state", strings.ToLower(onOff(s.DefaultState.State.On)))
+	}
+
+	switch {
+	case s.PowerProtection == nil:
+		line("Power protection", unsupported)
+	case s.PowerProtection.Enabled:
+		line("Power protection", fmt.Sprintf("%d W", s.PowerProtection.Watts))
+	default:
+		line("Power protection", "off")
+	}
+
+	switch {
+	case s.AutoOff == nil:
+		line("Auto-off", unsupported)
+	case s.AutoOff.Enable:
+		line("Auto-off", (time.Duration(s.AutoOff.DelayMin) * time.Minute).String())
+	default:
+		line("Auto-off", "off")
+	}
+
+	switch {
+	case s.ChildLock == nil:
+		line("Child lock", unsupported)
+	case *s.ChildLock:
+		line("Child lock", "on")
+	default:
+		line("Child lock", "off")
+	}
+}
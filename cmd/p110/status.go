@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abhishek/p110/internal/config"
+	"github.com/abhishek/p110/internal/tapo"
+)
+
+// statusConcurrency limits how many devices `p110 status` queries at once.
+const statusConcurrency = 8
+
+// statusRow is one device in `p110 status` output.
+type statusRow struct {
+	Name            string   `json:"name"`
+	Model           string   `json:"model"`
+	IP              string   `json:"ip"`
+	MAC             string   `json:"mac"`
+	On              bool     `json:"on"`
+	PowerW          *float64 `json:"power_w,omitempty"`
+	TodayKWh        *float64 `json:"today_kwh,omitempty"`
+	MonthKWh        *float64 `json:"month_kwh,omitempty"`
+	RSSI            int      `json:"rssi"`
+	SignalLevel     int      `json:"signal_level"`
+	OnTimeS         int      `json:"on_time_s"`
+	OverHeated      bool     `json:"overheated"`
+	PowerProtection string   `json:"power_protection,omitempty"`
+	Firmware        string   `json:"firmware"`
+	LatencyMS       int      `json:"latency_ms"`
+	LastError       string   `json:"last_error,omitempty"`
+}
+
+// runStatus implements `p110 status`: one row per device, queried concurrently.
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	conn := addConnFlags(fs)
+	sortBy := fs.String("sort", "name", "Sort by name, ip, model, power, today, month, rssi, on-time or latency")
+	filter := fs.String("filter", "", "Only show devices whose name contains this text")
+	state := fs.String("state", "", "Only show devices that are on, off or failing (error)")
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	fs.Parse(args)
+
+	switch *format {
+	case "table", "csv", "json":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown -format %q\n", *format)
+		os.Exit(1)
+	}
+
+	cfg := conn.config()
+	client := conn.client()
+
+	// The fleet view covers every device unless one is named.
+	if *conn.ip == "" {
+		*conn.all = true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	deviceIPs := conn.deviceIPs(ctx)
+	cancel()
+
+	rows := make([]statusRow, len(deviceIPs))
+	sem := make(chan struct{}, statusConcurrency)
+	var wg sync.WaitGroup
+	for i, ip := range deviceIPs {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			rows[i] = deviceStatus(client, cfg, ip)
+		}(i, ip)
+	}
+	wg.Wait()
+
+	rows = filterStatus(rows, *filter, *state)
+	if err := sortStatus(rows, *sortBy); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rows)
+	case "csv":
+		printStatusCSV(rows)
+	default:
+		printStatusTable(rows)
+	}
+}
+
+// deviceStatus queries one device for its status row.
+func deviceStatus(client *tapo.Client, cfg *config.Config, ip string) statusRow {
+	row := statusRow{Name: ip, IP: ip}
+	if d := cfg.Device(ip); d != nil {
+		row.Name = d.Name
+	}
+
+	start := time.Now()
+	device, err := client.Connect(ip)
+	if err != nil {
+		row.LastError = err.Error()
+		return row
+	}
+
+	info, err := device.GetDeviceInfo()
+	if err != nil {
+		row.LastError = err.Error()
+		return row
+	}
+	row.LatencyMS = int(time.Since(start).Milliseconds())
+	row.Model = info.Model
+	row.MAC = info.MAC
+	row.On = info.DeviceON
+	row.RSSI = info.RSSI
+	row.SignalLevel = info.SignalLevel
+	row.OnTimeS = info.OnTime
+	row.OverHeated = info.OverHeated
+	row.PowerProtection = info.PowerProtectionStatus
+	row.Firmware = firmwareVersion(info.FirmwareVersion)
+	if d := cfg.Device(info.MAC); d != nil {
+		row.Name = d.Name
+	} else if row.Name == ip && info.Nickname != "" {
+		row.Name = info.Nickname
+	}
+
+	if !tapo.DetectCapabilities(info.Model, info.Type).Energy {
+		return row
+	}
+
+	if power, err := device.GetCurrentPower(); err != nil {
+		row.LastError = err.Error()
+	} else {
+		w := float64(power.CurrentPower) / 1000.0
+		row.PowerW = &w
+	}
+	if usage, err := device.GetEnergyUsage(); err != nil {
+		row.LastError = err.Error()
+	} else {
+		today := float64(usage.TodayEnergy) / 1000.0
+		month := float64(usage.MonthEnergy) / 1000.0
+		row.TodayKWh, row.MonthKWh = &today, &month
+	}
+	return row
+}
+
+// filterStatus keeps rows whose name contains filter and whose state matches.
+func filterStatus(rows []statusRow, filter, state string) []statusRow {
+	filter = strings.ToLower(filter)
+	state = strings.ToLower(state)
+
+	var kept []statusRow
+	for _, r := range rows {
+		if filter != "" && !strings.Contains(strings.ToLower(r.Name), filter) {
+			continue
+		}
+		switch state {
+		case "on":
+			if !r.On || r.LastError != "" {
+				continue
+			}
+		case "off":
+			if r.On || r.LastError != "" {
+				continue
+			}
+		case "error":
+			if r.LastError == "" {
+				continue
+			}
+		}
+		kept = append(kept, r)
+	}
+	return kept
+}
+
+// sortStatus orders rows by the named column. Numeric columns sort highest first.
+func sortStatus(rows []statusRow, by string) error {
+	num := func(v *float64) float64 {
+		if v == nil {
+			return -1
+		}
+		return *v
+	}
+
+	var less func(a, b statusRow) bool
+	switch by {
+	case "name":
+		less = func(a, b statusRow) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	case "ip":
+		less = func(a, b statusRow) bool { return ipLess(a.IP, b.IP) }
+	case "model":
+		less = func(a, b statusRow) bool { return a.Model < b.Model }
+	case "power":
+		less = func(a, b statusRow) bool { return num(a.PowerW) > num(b.PowerW) }
+	case "today":
+		less = func(a, b statusRow) bool { return num(a.TodayKWh) > num(b.TodayKWh) }
+	case "month":
+		less = func(a, b statusRow) bool { return num(a.MonthKWh) > num(b.MonthKWh) }
+	case "rssi":
+		less = func(a, b statusRow) bool { return a.RSSI > b.RSSI }
+	case "on-time":
+		less = func(a, b statusRow) bool { return a.OnTimeS > b.OnTimeS }
+	case "latency":
+		less = func(a, b statusRow) bool { return a.LatencyMS > b.LatencyMS }
+	default:
+		return fmt.Errorf("unknown -sort %q", by)
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool { return less(rows[i], rows[j]) })
+	return nil
+}
+
+// ipLess compares dotted IPv4 addresses numerically.
+func ipLess(a, b string) bool {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		x, _ := strconv.Atoi(pa[i])
+		y, _ := strconv.Atoi(pb[i])
+		if x != y {
+			return x < y
+		}
+	}
+	return len(pa) < len(pb)
+}
+
+func formatOptional(v *float64, format string) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf(format, *v)
+}
+
+func formatOnTime(seconds int) string {
+	if seconds <= 0 {
+		return "-"
+	}
+	d := time.Duration(seconds) * time.Second
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("%dd%dh", int(d.Hours())/24, int(d.Hours())%24)
+	}
+	return d.Truncate(time.Minute).String()
+}
+
+func printStatusTable(rows []statusRow) {
+	fmt.Printf("%-20s %-7s %-15s %-17s %-4s %8s %8s %8s %5s %8s %-8s %s\n",
+		"Name", "Model", "IP", "MAC", "", "W", "Today", "Month", "RSSI", "On for", "Firmware", "Error")
+	fmt.Println(strings.Repeat("─", 120))
+	for _, r := range rows {
+		state := onOff(r.On)
+		if r.LastError != "" && r.Model == "" {
+			state = "?"
+		}
+		fmt.Printf("%-20s %-7s %-15s %-17s %-4s %8s %8s %8s %5d %8s %-8s %s\n",
+			truncate(r.Name, 20), r.Model, r.IP, r.MAC, state,
+			formatOptional(r.PowerW, "%.1f"), formatOptional(r.TodayKWh, "%.3f"), formatOptional(r.MonthKWh, "%.2f"),
+			r.RSSI, formatOnTime(r.OnTimeS), r.Firmware, r.LastError)
+	}
+}
+
+func printStatusCSV(rows []statusRow) {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"name", "model", "ip", "mac", "on", "power_w", "today_kwh", "month_kwh", "rssi", "signal_level", "on_time_s", "overheated", "power_protection", "firmware", "latency_ms", "last_error"})
+	opt := func(v *float64, prec int) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'f', prec, 64)
+	}
+	for _, r := range rows {
+		w.Write([]string{
+			r.Name, r.Model, r.IP, r.MAC, strconv.FormatBool(r.On),
+			opt(r.PowerW, 1), opt(r.TodayKWh, 3), opt(r.MonthKWh, 3),
+			strconv.Itoa(r.RSSI), strconv.Itoa(r.SignalLevel), strconv.Itoa(r.OnTimeS), strconv.FormatBool(r.OverHeated),
+			r.PowerProtection, r.Firmware, strconv.Itoa(r.LatencyMS), r.LastError,
+		})
+	}
+	w.Flush()
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/abhishek/p110/internal/tapo"
+	"github.com/abhishek/p110/internal/tariff"
+)
+
+const sparkWidth = 30
+
+var sparkChars = []rune("▁▂▃▄▅▆▇█")
+
+// watchedPlug is the live state of one plug in watch mode.
+type watchedPlug struct {
+	ip      string
+	device  *tapo.P110
+	name    string
+	model   string
+	on      bool
+	watts   float64
+	todayWh int
+	history []float64 // NaN marks a failed refresh
+	err     error
+}
+
+// runWatch implements `p110 watch`: a live, redrawing view of one or more plugs.
+func runWatch(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	conn := addConnFlags(fs)
+	interval := fs.Duration("interval", 2*time.Second, "Refresh interval")
+	window := fs.Duration("window", 5*time.Minute, "Time span covered by the sparkline")
+	rate := fs.Float64("rate", 0, "Electricity rate per kWh for cost calculation (overrides config tariffs)")
+	currency := fs.String("currency", "₹", "Currency symbol for cost display")
+	fs.Parse(args)
+
+	if *interval <= 0 || *window <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: -interval and -window must be positive")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg := conn.config()
+	prices := loadPricing(fs, cfg, *rate, *currency)
+
+	client := conn.client()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	deviceIPs := conn.deviceIPs(ctx)
+	cancel()
+
+	samples := int(*window / *interval)
+	if samples < 2 {
+		samples = 2
+	}
+
+	plugs := make([]*watchedPlug, len(deviceIPs))
+	for i, ip := range deviceIPs {
+		plugs[i] = &watchedPlug{ip: ip, name: ip}
+	}
+
+	interactive := term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	var keys <-chan byte
+	if interactive {
+		oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to set up terminal: %v\n", err)
+			os.Exit(1)
+		}
+		// Alternate screen, hidden cursor; both restored on exit.
+		fmt.Print("\x1b[?1049h\x1b[?25l")
+		defer func() {
+			fmt.Print("\x1b[?25h\x1b[?1049l")
+			term.Restore(int(os.Stdin.Fd()), oldState)
+		}()
+		keys = readKeys(os.Stdin)
+	}
+
+	selected := 0
+	status := ""
+	pollPlugs(client, plugs, samples)
+	drawWatch(plugs, selected, status, interactive, *interval, prices)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pollPlugs(client, plugs, samples)
+		case <-sigChan:
+			return
+		case key := <-keys:
+			switch key {
+			case 'q', 3: // q or Ctrl-C
+				return
+			case 'k', 'A': // up, or the final byte of the up-arrow sequence
+				if selected > 0 {
+					selected--
+				}
+			case 'j', 'B': // down
+				if selected < len(plugs)-1 {
+					selected++
+				}
+			case 't', ' ':
+				status = togglePlug(plugs[selected])
+				pollPlugs(client, plugs, samples)
+			default:
+				continue
+			}
+		}
+		drawWatch(plugs, selected, status, interactive, *interval, prices)
+	}
+}
+
+// readKeys delivers single bytes read from r until it fails.
+func readKeys(r io.Reader) <-chan byte {
+	keys := make(chan byte, 16)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := r.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, b := range buf[:n] {
+				keys <- b
+			}
+		}
+	}()
+	return keys
+}
+
+// pollPlugs refreshes every plug concurrently over its existing session,
+// reconnecting plugs whose session was dropped after an error.
+func pollPlugs(client *tapo.Client, plugs []*watchedPlug, samples int) {
+	var wg sync.WaitGroup
+	for _, p := range plugs {
+		wg.Add(1)
+		go func(p *watchedPlug) {
+			defer wg.Done()
+			p.err = p.refresh(client)
+			sample := p.watts
+			if p.err != nil {
+				p.device = nil
+				sample = math.NaN() // a gap, not the last good reading again
+			}
+			p.history = append(p.history, sample)
+			if len(p.history) > samples {
+				p.history = p.history[len(p.history)-samples:]
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+// refresh reads the plug's current state.
+func (p *watchedPlug) refresh(client *tapo.Client) error {
+	if p.device == nil {
+		device, err := client.Connect(p.ip)
+		if err != nil {
+			return err
+		}
+		p.device = device
+	}
+
+	info, err := p.device.GetDeviceInfo()
+	if err != nil {
+		return err
+	}
+	p.on = info.DeviceON
+	p.model = info.Model
+	if info.Nickname != "" {
+		p.name = info.Nickname
+	}
+	if !tapo.DetectCapabilities(info.Model, info.Type).Energy {
+		return nil // switchable only
+	}
+
+	power, err := p.device.GetCurrentPower()
+	if err != nil {
+		return err
+	}
+	p.watts = float64(power.CurrentPower) / 1000.0
+
+	usage, err := p.device.GetEnergyUsage()
+	if err != nil {
+		return err
+	}
+	p.todayWh = usage.TodayEnergy
+
+	return nil
+}
+
+// togglePlug flips a plug's on/off state and returns a status line describing the result.
+func togglePlug(p *watchedPlug) string {
+	if p.device == nil {
+		return fmt.Sprintf("%s is not connected", p.name)
+	}
+
+	var err error
+	action := "ON"
+	if p.on {
+		action = "OFF"
+		err = p.device.TurnOff()
+	} else {
+		err = p.device.TurnOn()
+	}
+	if err != nil {
+		return fmt.Sprintf("Failed to turn %s %s: %v", p.name, strings.ToLower(action), err)
+	}
+	return fmt.Sprintf("%s turned %s", p.name, action)
+}
+
+// drawWatch renders the current state: a full-screen table on a terminal,
+// or one plain line per plug otherwise.
+func drawWatch(plugs []*watchedPlug, selected int, status string, interactive bool, interval time.Duration, prices pricing) {
+	now := time.Now()
+	todayCost := func(p *watchedPlug) string {
+		cost := prices.tariff.Cost([]tariff.Usage{tariff.Day(now, p.todayWh, now)}).Total
+		return prices.format(cost, 2)
+	}
+
+	if !interactive {
+		ts := now.Format("2006-01-02 15:04:05")
+		for _, p := range plugs {
+			if p.err != nil {
+				fmt.Printf("%s %s %s error: %v\n", ts, p.ip, p.name, p.err)
+				continue
+			}
+			line := fmt.Sprintf("%s %s %s %s %.1f W today %.3f kWh", ts, p.ip, p.name, onOff(p.on), p.watts, float64(p.todayWh)/1000.0)
+			if prices.tariff != nil {
+				line += " " + todayCost(p)
+			}
+			fmt.Println(line)
+		}
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "p110 watch — %s — every %v\r\n", now.Format("15:04:05"), interval)
+	b.WriteString(strings.Repeat("─", 70) + "\r\n")
+
+	for i, p := range plugs {
+		marker := " "
+		if i == selected {
+			marker = ">"
+		}
+		fmt.Fprintf(&b, "%s %-20s %-15s %-3s %8.1f W  %s\r\n",
+			marker, truncate(p.name, 20), p.ip, onOff(p.on), p.watts, sparkline(p.history))
+
+		detail := fmt.Sprintf("%s  Today: %.3f kWh", p.model, float64(p.todayWh)/1000.0)
+		if prices.tariff != nil {
+			detail += fmt.Sprintf(" (%s)", todayCost(p))
+		}
+		if p.err != nil {
+			detail += "  error: " + p.err.Error()
+		}
+		fmt.Fprintf(&b, "  %s\r\n", detail)
+	}
+
+	b.WriteString(strings.Repeat("─", 70) + "\r\n")
+	if status != "" {
+		b.WriteString(status + "\r\n")
+	}
+	b.WriteString("↑/↓ or j/k select   t/space toggle   q quit\r\n")
+	fmt.Print(b.String())
+}
+
+// sparkline renders values as a row of block characters scaled to their
+// maximum, with a space for each NaN gap. Longer series are averaged down to
+// sparkWidth columns, skipping gaps.
+func sparkline(values []float64) string {
+	if len(values) > sparkWidth {
+		buckets := make([]float64, sparkWidth)
+		for i := range buckets {
+			start := i * len(values) / sparkWidth
+			end := (i + 1) * len(values) / sparkWidth
+			total, n := 0.0, 0
+			for _, v := range values[start:end] {
+				if !math.IsNaN(v) {
+					total += v
+					n++
+				}
+			}
+			buckets[i] = math.NaN()
+			if n > 0 {
+				buckets[i] = total / float64(n)
+			}
+		}
+		values = buckets
+	}
+
+	max := 0.0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		if math.IsNaN(v) {
+			b.WriteRune(' ')
+			continue
+		}
+		idx := 0
+		if max > 0 {
+			idx = int(v / max * float64(len(sparkChars)-1))
+		}
+		b.WriteRune(sparkChars[idx])
+	}
+	return b.String()
+}
+
+func onOff(on bool) string {
+	if on {
+		return "ON"
+	}
+	return "OFF"
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -4,6 +4,7 @@ go 1.25
 
 require (
 	github.com/google/uuid v1.6.0
+	golang.org/x/term v0.15.0
 	modernc.org/sqlite v1.29.1
 )
 
@@ -0,0 +1,265 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abhishek/p110/internal/duration"
+)
+
+// Condition names what a rule watches for.
+type Condition string
+
+const (
+	// PowerAbove fires when power stays above Watts for For.
+	PowerAbove Condition = "power_above"
+	// PowerBelow fires when power stays below Watts for For, optionally only
+	// while the plug is switched on (an appliance that has finished).
+	PowerBelow Condition = "power_below"
+	// Offline fires when the device misses Polls consecutive polls.
+	Offline Condition = "offline"
+	// Overheated fires when the device reports overheating.
+	Overheated Condition = "overheated"
+)
+
+// DefaultCooldown is used when a rule doesn't set one.
+const DefaultCooldown = 30 * time.Minute
+
+// Rule is one alert rule from the config file.
+type Rule struct {
+	Name      string            `json:"name"`
+	Device    string            `json:"device,omitempty"` // name, IP or MAC; empty matches every device
+	Condition Condition         `json:"condition"`
+	Watts     float64           `json:"watts,omitempty"`
+	For       duration.Duration `json:"for,omitempty"`
+	Polls     int               `json:"polls,omitempty"`
+	WhileOn   bool              `json:"while_on,omitempty"`
+	Cooldown  duration.Duration `json:"cooldown,omitempty"` // minimum time between notifications
+	Resolved  bool              `json:"notify_resolved,omitempty"`
+	Notify    []string          `json:"notify,omitempty"` // notifier names; empty means all
+}
+
+// Observation is what the daemon saw of one device on one poll.
+type Observation struct {
+	Time       time.Time
+	DeviceIP   string
+	DeviceMAC  string
+	Name       string // config name or nickname
+	Online     bool
+	HavePower  bool
+	PowerW     float64
+	DeviceOn   bool
+	OverHeated bool
+}
+
+// Alert is a notification sent when a rule starts or stops firing.
+type Alert struct {
+	Rule     string    `json:"rule"`
+	Device   string    `json:"device"`
+	DeviceIP string    `json:"device_ip"`
+	State    string    `json:"state"` // "firing" or "resolved"
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+	PowerW   float64   `json:"power_w"`
+}
+
+// Title returns a one-line summary for notifiers with a subject field.
+func (a Alert) Title() string {
+	if a.State == "resolved" {
+		return fmt.Sprintf("[resolved] %s: %s", a.Device, a.Rule)
+	}
+	return fmt.Sprintf("%s: %s", a.Device, a.Rule)
+}
+
+// ruleState tracks one rule for one device between polls.
+type ruleState struct {
+	since    time.Time // when the condition became true; zero if false
+	misses   int       // consecutive offline polls
+	firing   bool
+	lastSent time.Time
+}
+
+// Engine evaluates alert rules against each poll and sends notifications.
+type Engine struct {
+	rules     []Rule
+	notifiers map[string]Notifier
+
+	mu     sync.Mutex
+	states map[string]*ruleState
+}
+
+// NewEngine validates the rules against the available notifiers. Defaults
+// are applied to a copy, leaving the caller's rules untouched.
+func NewEngine(rules []Rule, notifiers map[string]Notifier) (*Engine, error) {
+	rules = append([]Rule(nil), rules...)
+	for i, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("alert rule %d has no name", i+1)
+		}
+		switch r.Condition {
+		case PowerAbove, PowerBelow, Overheated:
+		case Offline:
+			if r.Polls < 1 {
+				rules[i].Polls = 1
+			}
+		default:
+			return nil, fmt.Errorf("alert rule %q: unknown condition %q", r.Name, r.Condition)
+		}
+		for _, n := range r.Notify {
+			if _, ok := notifiers[n]; !ok {
+				return nil, fmt.Errorf("alert rule %q: unknown notifier %q", r.Name, n)
+			}
+		}
+	}
+
+	return &Engine{
+		rules:     rules,
+		notifiers: notifiers,
+		states:    make(map[string]*ruleState),
+	}, nil
+}
+
+// matches reports whether the rule applies to the observed device.
+func (r *Rule) matches(o Observation) bool {
+	if r.Device == "" {
+		return true
+	}
+	return strings.EqualFold(r.Device, o.Name) ||
+		r.Device == o.DeviceIP ||
+		(o.DeviceMAC != "" && strings.EqualFold(strings.ReplaceAll(r.Device, ":", "-"), o.DeviceMAC))
+}
+
+// Observe evaluates every matching rule against an observation. Notifications
+// are sent in the background.
+func (e *Engine) Observe(o Observation) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := range e.rules {
+		r := &e.rules[i]
+		if !r.matches(o) {
+			continue
+		}
+
+		key := r.Name + "|" + o.DeviceIP
+		st := e.states[key]
+		if st == nil {
+			st = &ruleState{}
+			e.states[key] = st
+		}
+
+		active, known, message := r.evaluate(o, st)
+		if !known {
+			continue // no data to judge by this poll
+		}
+
+		switch {
+		case active && !st.firing:
+			cooldown := time.Duration(r.Cooldown)
+			if cooldown == 0 {
+				cooldown = DefaultCooldown
+			}
+			if !st.lastSent.IsZero() && o.Time.Sub(st.lastSent) < cooldown {
+				continue
+			}
+			st.firing = true
+			st.lastSent = o.Time
+			e.send(r, o, "firing", message)
+		case !active && st.firing:
+			st.firing = false
+			if r.Resolved {
+				e.send(r, o, "resolved", message)
+			}
+		}
+	}
+}
+
+// evaluate updates the rule state and reports whether the condition holds,
+// whether it could be judged at all, and a human-readable description.
+func (r *Rule) evaluate(o Observation, st *ruleState) (active, known bool, message string) {
+	name := o.Name
+	if name == "" {
+		name = o.DeviceIP
+	}
+
+	switch r.Condition {
+	case Offline:
+		if o.Online {
+			st.misses = 0
+			return false, true, fmt.Sprintf("%s is back online", name)
+		}
+		st.misses++
+		return st.misses >= r.Polls, true, fmt.Sprintf("%s has missed %d polls", name, st.misses)
+
+	case Overheated:
+		if !o.Online {
+			return false, false, ""
+		}
+		if o.OverHeated {
+			return true, true, fmt.Sprintf("%s reports overheating", name)
+		}
+		return false, true, fmt.Sprintf("%s is no longer overheated", name)
+	}
+
+	if !o.Online || !o.HavePower {
+		return false, false, ""
+	}
+
+	var holds bool
+	var verb string
+	if r.Condition == PowerAbove {
+		holds, verb = o.PowerW > r.Watts, "above"
+	} else {
+		holds, verb = o.PowerW < r.Watts && (!r.WhileOn || o.DeviceOn), "below"
+	}
+
+	if !holds {
+		st.since = time.Time{}
+		return false, true, fmt.Sprintf("%s power is back to %.1f W", name, o.PowerW)
+	}
+	if st.since.IsZero() {
+		st.since = o.Time
+	}
+	held := o.Time.Sub(st.since)
+	return held >= time.Duration(r.For), true,
+		fmt.Sprintf("%s power %.1f W has been %s %.0f W for %s", name, o.PowerW, verb, r.Watts, held.Round(time.Second))
+}
+
+// send delivers an alert to the rule's notifiers.
+func (e *Engine) send(r *Rule, o Observation, state, message string) {
+	a := Alert{
+		Rule:     r.Name,
+		Device:   o.Name,
+		DeviceIP: o.DeviceIP,
+		State:    state,
+		Message:  message,
+		Time:     o.Time,
+		PowerW:   o.PowerW,
+	}
+	if a.Device == "" {
+		a.Device = o.DeviceIP
+	}
+
+	names := r.Notify
+	if len(names) == 0 {
+		for name := range e.notifiers {
+			names = append(names, name)
+		}
+	}
+
+	log.Printf("[%s] Alert %s (%s): %s", o.DeviceIP, r.Name, state, message)
+	for _, name := range names {
+		n := e.notifiers[name]
+		go func(name string) {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			if err := n.Notify(ctx, a); err != nil {
+				log.Printf("[%s] Notifier %s failed: %v", o.DeviceIP, name, err)
+			}
+		}(name)
+	}
+}
@@ -0,0 +1,249 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const notifyTimeout = 15 * time.Second
+
+// Notifier delivers alerts somewhere.
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// NotifierConfig describes one notifier in the config file. Which fields are
+// used depends on Type.
+type NotifierConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // webhook, ntfy, gotify, email or exec
+
+	// webhook, ntfy, gotify
+	URL      string            `json:"url,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Token    string            `json:"token,omitempty"`
+	Priority int               `json:"priority,omitempty"`
+
+	// email
+	SMTPHost string   `json:"smtp_host,omitempty"`
+	SMTPPort int      `json:"smtp_port,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+
+	// exec
+	Command []string `json:"command,omitempty"`
+}
+
+// NewNotifier builds a notifier from its configuration.
+func NewNotifier(cfg NotifierConfig) (Notifier, error) {
+	switch cfg.Type {
+	case "webhook":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("notifier %q: url is required", cfg.Name)
+		}
+		return &webhookNotifier{cfg: cfg}, nil
+	case "ntfy":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("notifier %q: url is required", cfg.Name)
+		}
+		return &ntfyNotifier{cfg: cfg}, nil
+	case "gotify":
+		if cfg.URL == "" || cfg.Token == "" {
+			return nil, fmt.Errorf("notifier %q: url and token are required", cfg.Name)
+		}
+		return &gotifyNotifier{cfg: cfg}, nil
+	case "email":
+		if cfg.SMTPHost == "" || cfg.From == "" || len(cfg.To) == 0 {
+			return nil, fmt.Errorf("notifier %q: smtp_host, from and to are required", cfg.Name)
+		}
+		if cfg.SMTPPort == 0 {
+			cfg.SMTPPort = 587
+		}
+		return &emailNotifier{cfg: cfg}, nil
+	case "exec":
+		if len(cfg.Command) == 0 {
+			return nil, fmt.Errorf("notifier %q: command is required", cfg.Name)
+		}
+		return &execNotifier{cfg: cfg}, nil
+	default:
+		return nil, fmt.Errorf("notifier %q: unknown type %q", cfg.Name, cfg.Type)
+	}
+}
+
+// NewNotifiers builds all configured notifiers, keyed by name.
+func NewNotifiers(configs []NotifierConfig) (map[string]Notifier, error) {
+	notifiers := make(map[string]Notifier, len(configs))
+	for _, cfg := range configs {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("notifier of type %q has no name", cfg.Type)
+		}
+		if _, dup := notifiers[cfg.Name]; dup {
+			return nil, fmt.Errorf("duplicate notifier %q", cfg.Name)
+		}
+		n, err := NewNotifier(cfg)
+		if err != nil {
+			return nil, err
+		}
+		notifiers[cfg.Name] = n
+	}
+	return notifiers, nil
+}
+
+var httpClient = &http.Client{Timeout: notifyTimeout}
+
+// post sends an HTTP POST and treats any non-2xx status as an error.
+func post(ctx context.Context, url, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// webhookNotifier posts the alert as JSON.
+type webhookNotifier struct {
+	cfg NotifierConfig
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return post(ctx, n.cfg.URL, "application/json", body, n.cfg.Headers)
+}
+
+// ntfyNotifier publishes to an ntfy topic URL.
+type ntfyNotifier struct {
+	cfg NotifierConfig
+}
+
+func (n *ntfyNotifier) Notify(ctx context.Context, a Alert) error {
+	headers := map[string]string{"Title": a.Title()}
+	if n.cfg.Priority > 0 {
+		headers["Priority"] = strconv.Itoa(n.cfg.Priority)
+	}
+	if a.State == "resolved" {
+		headers["Tags"] = "white_check_mark"
+	} else {
+		headers["Tags"] = "warning"
+	}
+	if n.cfg.Token != "" {
+		headers["Authorization"] = "Bearer " + n.cfg.Token
+	}
+	for k, v := range n.cfg.Headers {
+		headers[k] = v
+	}
+	return post(ctx, n.cfg.URL, "text/plain", []byte(a.Message), headers)
+}
+
+// gotifyNotifier posts to a Gotify server's message endpoint.
+type gotifyNotifier struct {
+	cfg NotifierConfig
+}
+
+func (n *gotifyNotifier) Notify(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    a.Title(),
+		"message":  a.Message,
+		"priority": n.cfg.Priority,
+	})
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(n.cfg.URL, "/") + "/message"
+	headers := map[string]string{"X-Gotify-Key": n.cfg.Token}
+	return post(ctx, url, "application/json", body, headers)
+}
+
+// emailNotifier sends the alert over SMTP.
+type emailNotifier struct {
+	cfg NotifierConfig
+}
+
+func (n *emailNotifier) Notify(ctx context.Context, a Alert) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", a.Title())
+	fmt.Fprintf(&msg, "Date: %s\r\n", a.Time.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\nDevice: %s (%s)\r\nTime: %s\r\n",
+		a.Message, a.Device, a.DeviceIP, a.Time.Format(time.RFC3339))
+
+	var auth smtp.Auth
+	if n.cfg.Username != "" {
+		auth = smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, n.cfg.SMTPHost)
+	}
+
+	addr := net.JoinHostPort(n.cfg.SMTPHost, strconv.Itoa(n.cfg.SMTPPort))
+	errc := make(chan error, 1)
+	go func() {
+		errc <- smtp.SendMail(addr, auth, n.cfg.From, n.cfg.To, msg.Bytes())
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// execNotifier runs a command with the alert as JSON on stdin and in
+// P110_ALERT_* environment variables.
+type execNotifier struct {
+	cfg NotifierConfig
+}
+
+func (n *execNotifier) Notify(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, n.cfg.Command[0], n.cfg.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"P110_ALERT_RULE="+a.Rule,
+		"P110_ALERT_DEVICE="+a.Device,
+		"P110_ALERT_DEVICE_IP="+a.DeviceIP,
+		"P110_ALERT_STATE="+a.State,
+		"P110_ALERT_MESSAGE="+a.Message,
+		fmt.Sprintf("P110_ALERT_POWER_W=%.1f", a.PowerW),
+	)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
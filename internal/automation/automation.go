@@ -0,0 +1,273 @@
+package automation
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abhishek/p110/internal/duration"
+	"github.com/abhishek/p110/internal/store"
+)
+
+// Action is what a rule does to the plug when its conditions hold.
+type Action string
+
+const (
+	TurnOn  Action = "turn_on"
+	TurnOff Action = "turn_off"
+)
+
+// DefaultCooldown is used when a rule doesn't set one.
+const DefaultCooldown = 10 * time.Minute
+
+// Rule is one automation rule from the config file. Every condition that is
+// set must hold for the rule to fire.
+type Rule struct {
+	Name   string `json:"name"`
+	Device string `json:"device"` // name, IP or MAC
+	Action Action `json:"action"`
+
+	// OnFor holds once the plug has been switched on for this long, as
+	// reported by the device.
+	OnFor duration.Duration `json:"on_for,omitempty"`
+	// PowerBelow and PowerAbove hold while power is below or above the given
+	// watts. With For set, every stored reading over that window must agree.
+	PowerBelow *float64          `json:"power_below,omitempty"`
+	PowerAbove *float64          `json:"power_above,omitempty"`
+	For        duration.Duration `json:"for,omitempty"`
+	// TodayKWhAbove holds once today's energy exceeds this many kWh.
+	TodayKWhAbove float64 `json:"today_kwh_above,omitempty"`
+
+	Cooldown duration.Duration `json:"cooldown,omitempty"` // minimum time between actions
+	DryRun   bool              `json:"dry_run,omitempty"`  // log and audit without switching
+}
+
+// State is what the daemon saw of one device on one poll.
+type State struct {
+	Time       time.Time
+	DeviceIP   string
+	DeviceMAC  string
+	Name       string // config name or nickname
+	DeviceOn   bool
+	OnTime     time.Duration
+	HavePower  bool
+	PowerW     float64
+	HaveEnergy bool
+	TodayWh    int
+}
+
+// Switch turns a plug on or off.
+type Switch interface {
+	TurnOn() error
+	TurnOff() error
+}
+
+// Engine evaluates automation rules after each poll and switches plugs,
+// recording every action in the store's audit log.
+type Engine struct {
+	rules    []Rule
+	db       *store.Store
+	interval time.Duration // poll interval, used to judge stored-reading coverage
+	dryRun   bool
+
+	mu        sync.Mutex
+	lastFired map[string]time.Time
+}
+
+// NewEngine validates the rules. With dryRun set, no rule switches a plug.
+func NewEngine(rules []Rule, db *store.Store, interval time.Duration, dryRun bool) (*Engine, error) {
+	for i, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("automation rule %d has no name", i+1)
+		}
+		if r.Device == "" {
+			return nil, fmt.Errorf("automation rule %q: device is required", r.Name)
+		}
+		switch r.Action {
+		case TurnOn:
+			if r.OnFor > 0 {
+				return nil, fmt.Errorf("automation rule %q: on_for cannot be used with %s", r.Name, r.Action)
+			}
+		case TurnOff:
+		default:
+			return nil, fmt.Errorf("automation rule %q: unknown action %q", r.Name, r.Action)
+		}
+		if r.OnFor == 0 && r.PowerBelow == nil && r.PowerAbove == nil && r.TodayKWhAbove == 0 {
+			return nil, fmt.Errorf("automation rule %q has no conditions", r.Name)
+		}
+		if r.For > 0 && r.PowerBelow == nil && r.PowerAbove == nil {
+			return nil, fmt.Errorf("automation rule %q: for requires power_below or power_above", r.Name)
+		}
+	}
+
+	return &Engine{
+		rules:     rules,
+		db:        db,
+		interval:  interval,
+		dryRun:    dryRun,
+		lastFired: make(map[string]time.Time),
+	}, nil
+}
+
+// matches reports whether the rule applies to the device.
+func (r *Rule) matches(s State) bool {
+	return strings.EqualFold(r.Device, s.Name) ||
+		r.Device == s.DeviceIP ||
+		(s.DeviceMAC != "" && strings.EqualFold(strings.ReplaceAll(r.Device, ":", "-"), s.DeviceMAC))
+}
+
+// Evaluate runs every matching rule against a device's state and switches
+// it through sw when a rule fires.
+func (e *Engine) Evaluate(s State, sw Switch) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := range e.rules {
+		r := &e.rules[i]
+		if !r.matches(s) {
+			continue
+		}
+		// Only act when the action would change something.
+		if (r.Action == TurnOff) != s.DeviceOn {
+			continue
+		}
+
+		key := r.Name + "|" + s.DeviceIP
+		cooldown := time.Duration(r.Cooldown)
+		if cooldown == 0 {
+			cooldown = DefaultCooldown
+		}
+		if last, ok := e.lastFired[key]; ok && s.Time.Sub(last) < cooldown {
+			continue
+		}
+
+		reason, ok, err := e.check(r, s)
+		if err != nil {
+			log.Printf("[%s] Automation %s: %v", s.DeviceIP, r.Name, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		e.lastFired[key] = s.Time
+		e.act(r, s, sw, reason)
+	}
+}
+
+// check reports whether all of the rule's conditions hold, and why.
+func (e *Engine) check(r *Rule, s State) (string, bool, error) {
+	var reasons []string
+
+	if r.OnFor > 0 {
+		if s.OnTime < time.Duration(r.OnFor) {
+			return "", false, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("on for %s", s.OnTime.Round(time.Minute)))
+	}
+
+	if r.PowerBelow != nil || r.PowerAbove != nil {
+		if !s.HavePower {
+			return "", false, nil
+		}
+		ok, err := e.powerHeld(r, s)
+		if err != nil || !ok {
+			return "", false, err
+		}
+		reason := fmt.Sprintf("power %.1f W", s.PowerW)
+		if r.PowerBelow != nil {
+			reason += fmt.Sprintf(" below %.1f W", *r.PowerBelow)
+		}
+		if r.PowerAbove != nil {
+			reason += fmt.Sprintf(" above %.1f W", *r.PowerAbove)
+		}
+		if r.For > 0 {
+			reason += fmt.Sprintf(" for %s", time.Duration(r.For))
+		}
+		reasons = append(reasons, reason)
+	}
+
+	if r.TodayKWhAbove > 0 {
+		if !s.HaveEnergy || float64(s.TodayWh)/1000.0 <= r.TodayKWhAbove {
+			return "", false, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("today %.2f kWh above %.2f kWh", float64(s.TodayWh)/1000.0, r.TodayKWhAbove))
+	}
+
+	return strings.Join(reasons, ", "), true, nil
+}
+
+// inRange reports whether a power value satisfies the rule's thresholds.
+func (r *Rule) inRange(w float64) bool {
+	if r.PowerBelow != nil && w >= *r.PowerBelow {
+		return false
+	}
+	if r.PowerAbove != nil && w <= *r.PowerAbove {
+		return false
+	}
+	return true
+}
+
+// powerHeld checks the live power and, when the rule has a For window, the
+// stored readings across it. The window must be covered from its start to
+// within one poll interval, so a freshly started daemon doesn't act on a
+// single reading.
+func (e *Engine) powerHeld(r *Rule, s State) (bool, error) {
+	if !r.inRange(s.PowerW) {
+		return false, nil
+	}
+	if r.For == 0 {
+		return true, nil
+	}
+
+	start := s.Time.Add(-time.Duration(r.For))
+	readings, err := e.db.GetReadingsRange(s.DeviceIP, start, s.Time)
+	if err != nil {
+		return false, fmt.Errorf("failed to read readings: %w", err)
+	}
+	if len(readings) == 0 || readings[0].Timestamp.Sub(start) > e.interval {
+		return false, nil
+	}
+	for _, rd := range readings {
+		if !r.inRange(float64(rd.PowerMW) / 1000.0) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// act switches the plug, unless in dry-run mode, and records the action.
+func (e *Engine) act(r *Rule, s State, sw Switch, reason string) {
+	dryRun := e.dryRun || r.DryRun
+	rec := store.ActionRecord{
+		Timestamp: s.Time,
+		Rule:      r.Name,
+		DeviceIP:  s.DeviceIP,
+		Action:    string(r.Action),
+		Reason:    reason,
+		DryRun:    dryRun,
+	}
+
+	if dryRun {
+		log.Printf("[%s] Automation %s: would %s (%s)", s.DeviceIP, r.Name, r.Action, reason)
+	} else {
+		var err error
+		if r.Action == TurnOn {
+			err = sw.TurnOn()
+		} else {
+			err = sw.TurnOff()
+		}
+		if err != nil {
+			rec.Error = err.Error()
+			log.Printf("[%s] Automation %s: %s failed: %v", s.DeviceIP, r.Name, r.Action, err)
+		} else {
+			log.Printf("[%s] Automation %s: %s (%s)", s.DeviceIP, r.Name, r.Action, reason)
+		}
+	}
+
+	if err := e.db.InsertAction(rec); err != nil {
+		log.Printf("[%s] Failed to store action: %v", s.DeviceIP, err)
+	}
+}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/abhishek/p110/internal/config"
+)
+
+// Entry is what is known about one device from discovery and device info.
+type Entry struct {
+	IP       string    `json:"ip"`
+	MAC      string    `json:"mac,omitempty"`
+	DeviceID string    `json:"device_id,omitempty"`
+	Model    string    `json:"model,omitempty"`
+	Nickname string    `json:"nickname,omitempty"`
+	SeenAt   time.Time `json:"seen_at"`
+
+	// Protocol details from discovery, e.g. KLAP on port 80.
+	Protocol string `json:"protocol,omitempty"`
+	HTTPPort int    `json:"http_port,omitempty"`
+	HTTPS    bool   `json:"https,omitempty"`
+}
+
+// Cache remembers devices between runs, so targets can be resolved by
+// nickname or MAC without discovering and querying every device each time.
+type Cache struct {
+	path    string
+	Devices []Entry `json:"devices"`
+}
+
+// DefaultPath returns the cache file under the user's cache directory.
+func DefaultPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "p110", "devices.json")
+}
+
+// New returns an empty cache that saves to path.
+func New(path string) *Cache {
+	return &Cache{path: path}
+}
+
+// Load reads the cache at path. A missing file gives an empty cache.
+func Load(path string) (*Cache, error) {
+	c := New(path)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device cache: %w", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse device cache %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Save writes the cache back to its file.
+func (c *Cache) Save() error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// Update adds or replaces the entry for a device, matched by MAC or, for
+// entries without one, by IP. Fields left empty keep their cached values.
+func (c *Cache) Update(e Entry) {
+	for i := range c.Devices {
+		d := &c.Devices[i]
+		if (e.MAC != "" && strings.EqualFold(d.MAC, e.MAC)) || (e.MAC == "" && d.IP == e.IP) {
+			if e.DeviceID == "" {
+				e.DeviceID = d.DeviceID
+			}
+			if e.Model == "" {
+				e.Model = d.Model
+			}
+			if e.Nickname == "" {
+				e.Nickname = d.Nickname
+			}
+			if e.Protocol == "" {
+				e.Protocol, e.HTTPPort, e.HTTPS = d.Protocol, d.HTTPPort, d.HTTPS
+			}
+			*d = e
+			return
+		}
+	}
+	c.Devices = append(c.Devices, e)
+}
+
+// Prune drops devices last seen before the given time.
+func (c *Cache) Prune(before time.Time) {
+	kept := c.Devices[:0]
+	for _, d := range c.Devices {
+		if !d.SeenAt.Before(before) {
+			kept = append(kept, d)
+		}
+	}
+	c.Devices = kept
+}
+
+// Find returns the cached devices whose IP, MAC, device ID or nickname
+// matches key, ignoring case and MAC separators.
+func (c *Cache) Find(key string) []Entry {
+	mac := config.NormalizeMAC(key)
+	var found []Entry
+	for _, d := range c.Devices {
+		if d.IP == key || (d.MAC != "" && config.NormalizeMAC(d.MAC) == mac) ||
+			(d.DeviceID != "" && d.DeviceID == key) || (d.Nickname != "" && strings.EqualFold(d.Nickname, key)) {
+			found = append(found, d)
+		}
+	}
+	return found
+}
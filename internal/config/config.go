@@ -0,0 +1,143 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/abhishek/p110/internal/alert"
+	"github.com/abhishek/p110/internal/automation"
+	"github.com/abhishek/p110/internal/cycle"
+	"github.com/abhishek/p110/internal/tariff"
+)
+
+// EnvPath is the environment variable consulted when no -config flag is given.
+const EnvPath = "P110_CONFIG"
+
+// Config is the optional JSON configuration file.
+type Config struct {
+	// AuthHash is the output of `p110 auth hash`, used instead of the
+	// account password when no username and password are given.
+	AuthHash string `json:"auth_hash,omitempty"`
+	// Accounts name other Tapo accounts, for devices registered to them.
+	Accounts    map[string]Account `json:"accounts,omitempty"`
+	Currency    string             `json:"currency,omitempty"`
+	Tariffs     []tariff.Tariff    `json:"tariffs,omitempty"`
+	Devices     []Device           `json:"devices,omitempty"`
+	Alerts      Alerts             `json:"alerts,omitempty"`
+	Automations []automation.Rule  `json:"automations,omitempty"`
+	// Groups name sets of devices, e.g. "office-monitors": ["left", "right"].
+	// Members are device names, IPs, MACs, nicknames or other groups.
+	Groups map[string][]string `json:"groups,omitempty"`
+}
+
+// Alerts configures the daemon's alert rules and where alerts are sent.
+type Alerts struct {
+	Rules     []alert.Rule           `json:"rules,omitempty"`
+	Notifiers []alert.NotifierConfig `json:"notifiers,omitempty"`
+}
+
+// Account locates the credentials of a Tapo account. Accounts with neither
+// field set are looked up in systemd credentials and the `p110 login`
+// secrets file.
+type Account struct {
+	AuthHash        string `json:"auth_hash,omitempty"`
+	CredentialsFile string `json:"credentials_file,omitempty"`
+}
+
+// Device names a plug so commands can refer to it by name, and holds
+// per-device settings.
+type Device struct {
+	Name  string        `json:"name"`
+	IP    string        `json:"ip,omitempty"`
+	MAC   string        `json:"mac,omitempty"`
+	Cycle *cycle.Config `json:"cycle,omitempty"`
+	// Account names the Tapo account the device is registered to, if not
+	// the default one.
+	Account string `json:"account,omitempty"`
+}
+
+// Load reads the configuration file at path. If path is empty, $P110_CONFIG
+// is used; if that is empty too, an empty configuration is returned.
+func Load(path string) (*Config, error) {
+	if path == "" {
+		path = os.Getenv(EnvPath)
+	}
+	if path == "" {
+		return &Config{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	return &cfg, nil
+}
+
+// Tariff returns the configured tariff schedule, or nil if none is configured.
+func (c *Config) Tariff() (*tariff.Schedule, error) {
+	if len(c.Tariffs) == 0 {
+		return nil, nil
+	}
+	return tariff.NewSchedule(c.Tariffs)
+}
+
+// Device returns the configured device whose name, IP or MAC matches key,
+// ignoring case, or nil if there is none.
+func (c *Config) Device(key string) *Device {
+	for i := range c.Devices {
+		d := &c.Devices[i]
+		if strings.EqualFold(d.Name, key) || d.IP == key || (d.MAC != "" && NormalizeMAC(d.MAC) == NormalizeMAC(key)) {
+			return d
+		}
+	}
+	return nil
+}
+
+// NormalizeMAC converts a MAC address to the AA-BB-CC-DD-EE-FF form Tapo
+// devices report.
+func NormalizeMAC(mac string) string {
+	return strings.ToUpper(strings.ReplaceAll(mac, ":", "-"))
+}
+
+// ExpandGroups replaces group names in keys with their members, recursively,
+// dropping duplicates. Keys that aren't groups are kept as they are.
+func (c *Config) ExpandGroups(keys []string) ([]string, error) {
+	var out []string
+	seen := make(map[string]bool)
+
+	var expand func(keys []string, path []string) error
+	expand = func(keys []string, path []string) error {
+		for _, key := range keys {
+			members, ok := c.Groups[key]
+			if !ok {
+				if !seen[key] {
+					seen[key] = true
+					out = append(out, key)
+				}
+				continue
+			}
+			for _, p := range path {
+				if p == key {
+					return fmt.Errorf("group %q contains itself", key)
+				}
+			}
+			if err := expand(members, append(path, key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := expand(keys, nil); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,88 @@
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/abhishek/p110/internal/tapo"
+)
+
+// Credentials for one Tapo account: a username and password, or an auth
+// hash from `p110 auth hash`.
+type Credentials struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	AuthHash string `json:"auth_hash,omitempty"`
+}
+
+// Valid reports whether the credentials are enough to authenticate.
+func (c *Credentials) Valid() bool {
+	return (c.Username != "" && c.Password != "") || c.AuthHash != ""
+}
+
+// Client returns a Tapo client for the credentials, preferring the
+// password, which also reaches Kasa KLAP v1 plugs.
+func (c *Credentials) Client() (*tapo.Client, error) {
+	if c.Username != "" && c.Password != "" {
+		return tapo.NewClient(c.Username, c.Password), nil
+	}
+	if c.AuthHash != "" {
+		return tapo.NewClientWithAuthHash(c.AuthHash)
+	}
+	return nil, errors.New("credentials need a username and password, or an auth hash")
+}
+
+// ReadFile reads credentials from a JSON file, which must not be readable
+// or writable by anyone but its owner.
+func ReadFile(path string) (*Credentials, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		return nil, fmt.Errorf("credentials file %s is accessible by others (mode %04o); run chmod 600 on it", path, perm)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+	var c Credentials
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials %s: %w", path, err)
+	}
+	if !c.Valid() {
+		return nil, fmt.Errorf("credentials file %s needs a username and password, or an auth_hash", path)
+	}
+	return &c, nil
+}
+
+// EnvCredentialsDirectory is where systemd places credentials loaded with
+// LoadCredential= or SetCredentialEncrypted=.
+const EnvCredentialsDirectory = "CREDENTIALS_DIRECTORY"
+
+// SystemdName returns the name of the systemd credential holding an
+// account: "p110" for the default account, "p110-<account>" otherwise.
+func SystemdName(account string) string {
+	if account == "" || account == DefaultAccount {
+		return "p110"
+	}
+	return "p110-" + account
+}
+
+// FromCredentialsDirectory reads the credentials systemd passed for an
+// account. It returns nil if the service has none.
+func FromCredentialsDirectory(account string) (*Credentials, error) {
+	dir := os.Getenv(EnvCredentialsDirectory)
+	if dir == "" {
+		return nil, nil
+	}
+	path := filepath.Join(dir, SystemdName(account))
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return ReadFile(path)
+}
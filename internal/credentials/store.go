@@ -0,0 +1,169 @@
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DefaultAccount names the account used for devices without an account of
+// their own.
+const DefaultAccount = "default"
+
+// EnvPassphrase holds the passphrase for the secrets file, for services
+// and scripts that can't prompt for it.
+const EnvPassphrase = "TAPO_PASSPHRASE"
+
+// kdfIterations is the PBKDF2-SHA256 work factor for new secrets files.
+const kdfIterations = 600000
+
+// Store holds the credentials of one or more accounts, saved encrypted
+// with a passphrase by `p110 login`.
+type Store struct {
+	path     string
+	Accounts map[string]Credentials `json:"accounts"`
+}
+
+// sealedStore is the file format: the Store as JSON, sealed with AES-256-GCM
+// under a key derived from the passphrase.
+type sealedStore struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+// DefaultStorePath returns the secrets file under the user's config directory.
+func DefaultStorePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "p110", "secrets")
+}
+
+// NewStore returns an empty store that saves to path.
+func NewStore(path string) *Store {
+	return &Store{path: path, Accounts: make(map[string]Credentials)}
+}
+
+// StoreExists reports whether a secrets file exists at path.
+func StoreExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// OpenStore decrypts the secrets file at path. A missing file gives an
+// empty store.
+func OpenStore(path, passphrase string) (*Store, error) {
+	s := NewStore(path)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets: %w", err)
+	}
+
+	var sealed sealedStore
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return nil, fmt.Errorf("failed to parse secrets %s: %w", path, err)
+	}
+	if sealed.Version != 1 || sealed.KDF != "pbkdf2-sha256" {
+		return nil, fmt.Errorf("secrets %s: unsupported format", path)
+	}
+
+	aead, err := newAEAD(passphrase, sealed.Salt, sealed.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, sealed.Nonce, sealed.Data, nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt secrets: wrong passphrase or corrupt file")
+	}
+	if err := json.Unmarshal(plain, s); err != nil {
+		return nil, fmt.Errorf("failed to parse secrets %s: %w", path, err)
+	}
+	if s.Accounts == nil {
+		s.Accounts = make(map[string]Credentials)
+	}
+	return s, nil
+}
+
+// Save encrypts the store with passphrase and writes it back, readable only
+// by its owner. An empty store removes the file.
+func (s *Store) Save(passphrase string) error {
+	if len(s.Accounts) == 0 {
+		if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	plain, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	sealed := sealedStore{
+		Version:    1,
+		KDF:        "pbkdf2-sha256",
+		Iterations: kdfIterations,
+		Salt:       make([]byte, 16),
+	}
+	if _, err := rand.Read(sealed.Salt); err != nil {
+		return err
+	}
+	aead, err := newAEAD(passphrase, sealed.Salt, sealed.Iterations)
+	if err != nil {
+		return err
+	}
+	sealed.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(sealed.Nonce); err != nil {
+		return err
+	}
+	sealed.Data = aead.Seal(nil, sealed.Nonce, plain, nil)
+
+	data, err := json.MarshalIndent(sealed, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Account returns the credentials saved for an account, or nil.
+func (s *Store) Account(name string) *Credentials {
+	c, ok := s.Accounts[name]
+	if !ok {
+		return nil
+	}
+	return &c
+}
+
+func newAEAD(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
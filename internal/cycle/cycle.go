@@ -0,0 +1,170 @@
+package cycle
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/abhishek/p110/internal/duration"
+)
+
+// Config controls how a power series is segmented into on-cycles.
+type Config struct {
+	// StartW is the power at or above which a cycle starts.
+	StartW float64 `json:"start_w"`
+	// StopW is the power below which a running cycle may end. Keeping it
+	// below StartW gives hysteresis, so noise around one threshold doesn't
+	// split a run.
+	StopW float64 `json:"stop_w"`
+	// MinOff is how long power must stay below StopW before the cycle ends,
+	// so pauses within a run (a washer soaking) don't end it.
+	MinOff duration.Duration `json:"min_off"`
+	// MinDuration discards cycles shorter than this.
+	MinDuration duration.Duration `json:"min_duration"`
+	// MaxGap ends a running cycle when samples stop arriving for this long.
+	// Zero disables the check.
+	MaxGap duration.Duration `json:"max_gap"`
+}
+
+// DefaultConfig suits appliances polled every few minutes.
+var DefaultConfig = Config{
+	StartW:      10,
+	StopW:       5,
+	MinOff:      duration.Duration(5 * time.Minute),
+	MinDuration: duration.Duration(2 * time.Minute),
+	MaxGap:      duration.Duration(30 * time.Minute),
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Fields missing from the JSON
+// keep their DefaultConfig values, so a config can override just one.
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type plain Config // without this method
+	cfg := plain(DefaultConfig)
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return err
+	}
+	*c = Config(cfg)
+	return nil
+}
+
+// Sample is one power reading.
+type Sample struct {
+	Time   time.Time
+	PowerW float64
+}
+
+// Cycle is one detected run of an appliance.
+type Cycle struct {
+	Start    time.Time
+	End      time.Time
+	EnergyWh float64
+	PeakW    float64
+}
+
+// Duration returns how long the cycle ran.
+func (c Cycle) Duration() time.Duration {
+	return c.End.Sub(c.Start)
+}
+
+// Detector segments a power series into cycles one sample at a time, so it
+// can run over stored readings or react to live ones.
+type Detector struct {
+	cfg Config
+
+	running   bool
+	cycle     Cycle
+	last      Sample
+	quiet     bool      // power is below StopW inside a running cycle
+	quietFrom time.Time // when the current quiet period started
+	quietWh   float64   // energy used during the quiet period
+}
+
+// NewDetector creates a detector with the given configuration.
+func NewDetector(cfg Config) *Detector {
+	return &Detector{cfg: cfg}
+}
+
+// Add feeds the next sample, which must not be older than the previous one.
+// It returns a cycle when the sample completes one, and nil otherwise.
+func (d *Detector) Add(s Sample) *Cycle {
+	var done *Cycle
+
+	if d.running && d.cfg.MaxGap > 0 && s.Time.Sub(d.last.Time) > time.Duration(d.cfg.MaxGap) {
+		end := d.last.Time
+		if d.quiet {
+			end = d.quietFrom
+		}
+		done = d.finish(end)
+	}
+
+	if !d.running {
+		if s.PowerW >= d.cfg.StartW {
+			d.running = true
+			d.quiet = false
+			d.cycle = Cycle{Start: s.Time, PeakW: s.PowerW}
+		}
+		d.last = s
+		return done
+	}
+
+	// Trapezoidal energy since the previous sample.
+	wh := (d.last.PowerW + s.PowerW) / 2 * s.Time.Sub(d.last.Time).Hours()
+	d.last = s
+
+	if s.PowerW < d.cfg.StopW {
+		if !d.quiet {
+			d.quiet = true
+			d.quietFrom = s.Time
+			d.quietWh = 0
+			d.cycle.EnergyWh += wh // the falling edge belongs to the cycle
+		} else {
+			d.quietWh += wh
+		}
+		if s.Time.Sub(d.quietFrom) >= time.Duration(d.cfg.MinOff) {
+			if c := d.finish(d.quietFrom); c != nil {
+				done = c
+			}
+		}
+		return done
+	}
+
+	if d.quiet {
+		d.quiet = false
+		d.cycle.EnergyWh += d.quietWh
+	}
+	d.cycle.EnergyWh += wh
+	if s.PowerW > d.cycle.PeakW {
+		d.cycle.PeakW = s.PowerW
+	}
+	return done
+}
+
+// finish ends the running cycle at end and returns it unless it is too short.
+func (d *Detector) finish(end time.Time) *Cycle {
+	d.running = false
+	d.quiet = false
+
+	c := d.cycle
+	c.End = end
+	if c.Duration() < time.Duration(d.cfg.MinDuration) {
+		return nil
+	}
+	return &c
+}
+
+// Running reports whether a cycle is in progress and when it started.
+func (d *Detector) Running() (time.Time, bool) {
+	return d.cycle.Start, d.running
+}
+
+// Detect runs a detector over a complete series, oldest first. A cycle still
+// running at the end of the series is not returned.
+func Detect(cfg Config, samples []Sample) []Cycle {
+	d := NewDetector(cfg)
+	var cycles []Cycle
+	for _, s := range samples {
+		if c := d.Add(s); c != nil {
+			cycles = append(cycles, *c)
+		}
+	}
+	return cycles
+}
@@ -0,0 +1,165 @@
+package forecast
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// weekdayWeeks is how many past weeks of the same weekday are averaged.
+	weekdayWeeks = 8
+	// recentDays is the window used for the recent trend.
+	recentDays = 7
+	// bandZ is the z-score of the reported band (80% two-sided).
+	bandZ = 1.2816
+)
+
+// Day is the energy used on one calendar day.
+type Day struct {
+	Date     time.Time
+	EnergyWh int
+}
+
+// Input is what a month-end projection is based on.
+type Input struct {
+	Now           time.Time
+	MonthToDateWh int   // energy this month so far, including today
+	TodayWh       int   // energy today so far
+	History       []Day // completed days before today, any order
+}
+
+// Forecast is a projected month-end total with an uncertainty band.
+type Forecast struct {
+	MonthToDateWh float64
+	ProjectedWh   float64
+	LowWh         float64
+	HighWh        float64
+	DaysElapsed   int
+	DaysInMonth   int
+	// Trend is the mean daily energy of the last week relative to the week
+	// before it (1.0 is flat). Zero when there isn't enough history.
+	Trend float64
+	// Remaining holds the expected energy for the rest of today and each
+	// remaining day of the month, in order.
+	Remaining []Day
+}
+
+// Project estimates month-end energy use. Each remaining day is expected to
+// use the average of the same weekday over recent weeks blended with the
+// average of the last week; the band comes from the spread of those samples.
+func Project(in Input) Forecast {
+	loc := in.Now.Location()
+	today := time.Date(in.Now.Year(), in.Now.Month(), in.Now.Day(), 0, 0, 0, 0, loc)
+	monthEnd := time.Date(in.Now.Year(), in.Now.Month()+1, 1, 0, 0, 0, 0, loc)
+
+	f := Forecast{
+		MonthToDateWh: float64(in.MonthToDateWh),
+		DaysElapsed:   in.Now.Day(),
+		DaysInMonth:   monthEnd.AddDate(0, 0, -1).Day(),
+	}
+
+	byDate := make(map[string]float64)
+	for _, d := range in.History {
+		if d.Date.Before(today) {
+			byDate[d.Date.Format("2006-01-02")] = float64(d.EnergyWh)
+		}
+	}
+
+	var recent, previous []float64
+	for i := 1; i <= 2*recentDays; i++ {
+		wh, ok := byDate[today.AddDate(0, 0, -i).Format("2006-01-02")]
+		if !ok {
+			continue
+		}
+		if i <= recentDays {
+			recent = append(recent, wh)
+		} else {
+			previous = append(previous, wh)
+		}
+	}
+	recentMean, recentSD := meanSD(recent)
+	if prevMean, _ := meanSD(previous); len(recent) > 0 && prevMean > 0 {
+		f.Trend = recentMean / prevMean
+	}
+
+	// Without any history, assume the rest of the month continues at the
+	// month-to-date daily average.
+	fallback := 0.0
+	if f.DaysElapsed > 1 {
+		fallback = float64(in.MonthToDateWh-in.TodayWh) / float64(f.DaysElapsed-1)
+	} else {
+		hours := in.Now.Sub(today).Hours()
+		if hours > 0 {
+			fallback = float64(in.TodayWh) / hours * 24
+		}
+	}
+
+	expect := func(day time.Time) (float64, float64) {
+		var samples []float64
+		for w := 1; w <= weekdayWeeks; w++ {
+			if wh, ok := byDate[day.AddDate(0, 0, -7*w).Format("2006-01-02")]; ok {
+				samples = append(samples, wh)
+			}
+		}
+		weekdayMean, weekdaySD := meanSD(samples)
+
+		switch {
+		case len(samples) > 0 && len(recent) > 0:
+			return (weekdayMean + recentMean) / 2, math.Sqrt((weekdaySD*weekdaySD + recentSD*recentSD) / 2)
+		case len(samples) > 0:
+			return weekdayMean, weekdaySD
+		case len(recent) > 0:
+			return recentMean, recentSD
+		default:
+			return fallback, fallback / 2
+		}
+	}
+
+	var variance float64
+	projected := float64(in.MonthToDateWh)
+
+	// Rest of today: the expected full day minus what has been used so far.
+	mean, sd := expect(today)
+	dayFraction := 1 - in.Now.Sub(today).Hours()/24
+	rest := math.Max(mean-float64(in.TodayWh), 0)
+	projected += rest
+	variance += (sd * dayFraction) * (sd * dayFraction)
+	f.Remaining = append(f.Remaining, Day{Date: today, EnergyWh: int(rest)})
+
+	for day := today.AddDate(0, 0, 1); day.Before(monthEnd); day = day.AddDate(0, 0, 1) {
+		mean, sd := expect(day)
+		projected += mean
+		variance += sd * sd
+		f.Remaining = append(f.Remaining, Day{Date: day, EnergyWh: int(mean)})
+	}
+
+	band := bandZ * math.Sqrt(variance)
+	f.ProjectedWh = projected
+	f.LowWh = math.Max(projected-band, float64(in.MonthToDateWh))
+	f.HighWh = projected + band
+
+	return f
+}
+
+// meanSD returns the mean and sample standard deviation of values.
+func meanSD(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+
+	var total float64
+	for _, v := range values {
+		total += v
+	}
+	mean := total / float64(len(values))
+
+	if len(values) < 2 {
+		return mean, 0
+	}
+
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)-1))
+}
@@ -20,6 +20,7 @@ type Reading struct {
 	DeviceIP  string
 	DeviceMAC string
 	PowerMW   int // milliwatts
+	DeviceOn  bool
 }
 
 // HourlyRecord represents archived hourly energy data.
@@ -49,6 +50,69 @@ type MonthlyRecord struct {
 	EnergyWh int
 }
 
+// CycleRecord represents a detected appliance on-cycle.
+type CycleRecord struct {
+	ID       int64
+	DeviceIP string
+	Start    time.Time
+	End      time.Time
+	EnergyWh float64
+	PeakMW   int
+}
+
+// ActionRecord is an audit log entry for an automation action.
+type ActionRecord struct {
+	ID        int64
+	Timestamp time.Time
+	Rule      string
+	DeviceIP  string
+	Action    string
+	Reason    string
+	DryRun    bool
+	Error     string // empty if the action succeeded
+}
+
+// EventRecord is a change in a device's state seen between two polls, such
+// as being switched off or rebooting.
+type EventRecord struct {
+	ID        int64
+	Timestamp time.Time
+	DeviceIP  string
+	DeviceMAC string
+	Kind      string // e.g. "power", "reboot", "ip", "connection"
+	OldValue  string
+	NewValue  string
+}
+
+// HealthRecord is a device's Wi-Fi and protection state at one poll. If the
+// poll stored a reading, ReadingID links to it.
+type HealthRecord struct {
+	ID              int64
+	Timestamp       time.Time
+	DeviceIP        string
+	DeviceMAC       string
+	ReadingID       int64 // 0 if there is no reading
+	RSSI            int   // dBm
+	SignalLevel     int   // 0-3 bars
+	OnTimeS         int
+	OverHeated      bool
+	PowerProtection string
+	Firmware        string
+	LatencyMS       int // connect and get_device_info round trip
+}
+
+// ChildDevice is a strip outlet stored as its own device. Its readings and
+// energy records use Key in place of a device IP.
+type ChildDevice struct {
+	Key       string
+	ParentIP  string
+	DeviceID  string
+	Position  int
+	Nickname  string
+	Model     string
+	UpdatedAt time.Time
+}
+
 // Open opens or creates a SQLite database at the given path.
 func Open(path string) (*Store, error) {
 	db, err := sql.Open("sqlite", path)
@@ -112,19 +176,128 @@ func (s *Store) init() error {
 		UNIQUE(year, month, device_ip)
 	);
 	CREATE INDEX IF NOT EXISTS idx_monthly_ym ON monthly(year, month);
+
+	CREATE TABLE IF NOT EXISTS cycles (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_ip TEXT NOT NULL,
+		start DATETIME NOT NULL,
+		end DATETIME NOT NULL,
+		energy_wh REAL NOT NULL,
+		peak_mw INTEGER NOT NULL,
+		UNIQUE(device_ip, start)
+	);
+	CREATE INDEX IF NOT EXISTS idx_cycles_start ON cycles(start);
+
+	CREATE TABLE IF NOT EXISTS actions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp DATETIME NOT NULL,
+		rule TEXT NOT NULL,
+		device_ip TEXT NOT NULL,
+		action TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		dry_run INTEGER NOT NULL,
+		error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_actions_ts ON actions(timestamp);
+
+	CREATE TABLE IF NOT EXISTS events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp DATETIME NOT NULL,
+		device_ip TEXT NOT NULL,
+		device_mac TEXT NOT NULL DEFAULT '',
+		kind TEXT NOT NULL,
+		old_value TEXT NOT NULL DEFAULT '',
+		new_value TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_events_ts ON events(timestamp);
+
+	CREATE TABLE IF NOT EXISTS device_health (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp DATETIME NOT NULL,
+		device_ip TEXT NOT NULL,
+		device_mac TEXT NOT NULL DEFAULT '',
+		reading_id INTEGER,
+		rssi INTEGER NOT NULL,
+		signal_level INTEGER NOT NULL,
+		on_time_s INTEGER NOT NULL,
+		overheated INTEGER NOT NULL,
+		power_protection TEXT NOT NULL DEFAULT '',
+		firmware TEXT NOT NULL DEFAULT '',
+		latency_ms INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_health_ts ON device_health(timestamp);
+	CREATE INDEX IF NOT EXISTS idx_health_reading ON device_health(reading_id);
+
+	CREATE TABLE IF NOT EXISTS child_devices (
+		device_key TEXT PRIMARY KEY,
+		parent_ip TEXT NOT NULL,
+		device_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		nickname TEXT NOT NULL DEFAULT '',
+		model TEXT NOT NULL DEFAULT '',
+		updated_at DATETIME NOT NULL
+	);
 	`
 
-	_, err := s.db.Exec(schema)
+	if _, err := s.db.Exec(schema); err != nil {
+		return err
+	}
+
+	// Columns added after the initial schema; existing databases are
+	// upgraded in place.
+	return s.addColumnIfMissing("readings", "device_on", "INTEGER NOT NULL DEFAULT 0")
+}
+
+// addColumnIfMissing adds a column to an existing table if it isn't there yet.
+func (s *Store) addColumnIfMissing(table, column, definition string) error {
+	rows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
 	return err
 }
 
-// InsertReading stores a power reading snapshot.
-func (s *Store) InsertReading(deviceIP, deviceMAC string, powerMW int) error {
-	_, err := s.db.Exec(
-		"INSERT INTO readings (timestamp, device_ip, device_mac, power_mw) VALUES (?, ?, ?, ?)",
-		time.Now().UTC(), deviceIP, deviceMAC, powerMW,
+// InsertReading stores a power reading snapshot and returns the stored reading.
+func (s *Store) InsertReading(deviceIP, deviceMAC string, powerMW int, deviceOn bool) (*Reading, error) {
+	r := Reading{
+		Timestamp: time.Now().UTC(),
+		DeviceIP:  deviceIP,
+		DeviceMAC: deviceMAC,
+		PowerMW:   powerMW,
+		DeviceOn:  deviceOn,
+	}
+
+	res, err := s.db.Exec(
+		"INSERT INTO readings (timestamp, device_ip, device_mac, power_mw, device_on) VALUES (?, ?, ?, ?, ?)",
+		r.Timestamp, r.DeviceIP, r.DeviceMAC, r.PowerMW, r.DeviceOn,
 	)
-	return err
+	if err != nil {
+		return nil, err
+	}
+
+	r.ID, err = res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
 }
 
 // InsertHourly stores or updates hourly energy data.
@@ -157,16 +330,261 @@ func (s *Store) InsertMonthly(year, month int, deviceIP string, energyWh int) er
 	return err
 }
 
+// InsertCycle stores or updates a detected cycle, keyed by device and start time.
+func (s *Store) InsertCycle(c CycleRecord) error {
+	_, err := s.db.Exec(
+		`INSERT INTO cycles (device_ip, start, end, energy_wh, peak_mw) VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT(device_ip, start) DO UPDATE SET end = excluded.end, energy_wh = excluded.energy_wh, peak_mw = excluded.peak_mw`,
+		c.DeviceIP, c.Start.UTC(), c.End.UTC(), c.EnergyWh, c.PeakMW,
+	)
+	return err
+}
+
+// GetCyclesRange returns cycles for a device that started within a time range.
+func (s *Store) GetCyclesRange(deviceIP string, start, end time.Time) ([]CycleRecord, error) {
+	rows, err := s.db.Query(
+		"SELECT id, device_ip, start, end, energy_wh, peak_mw FROM cycles WHERE device_ip = ? AND start >= ? AND start <= ? ORDER BY start",
+		deviceIP, start.UTC(), end.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []CycleRecord
+	for rows.Next() {
+		var r CycleRecord
+		var startTS, endTS string
+		if err := rows.Scan(&r.ID, &r.DeviceIP, &startTS, &endTS, &r.EnergyWh, &r.PeakMW); err != nil {
+			return nil, err
+		}
+		r.Start, _ = time.Parse(time.RFC3339, startTS)
+		r.End, _ = time.Parse(time.RFC3339, endTS)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// UpsertChildDevice records a strip outlet, updating its nickname and model.
+func (s *Store) UpsertChildDevice(c ChildDevice) error {
+	_, err := s.db.Exec(`
+		INSERT INTO child_devices (device_key, parent_ip, device_id, position, nickname, model, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(device_key) DO UPDATE SET
+			parent_ip = excluded.parent_ip,
+			device_id = excluded.device_id,
+			position = excluded.position,
+			nickname = excluded.nickname,
+			model = excluded.model,
+			updated_at = excluded.updated_at`,
+		c.Key, c.ParentIP, c.DeviceID, c.Position, c.Nickname, c.Model, c.UpdatedAt.UTC(),
+	)
+	return err
+}
+
+// GetChildDevices returns the outlets recorded for a strip.
+func (s *Store) GetChildDevices(parentIP string) ([]ChildDevice, error) {
+	rows, err := s.db.Query(
+		"SELECT device_key, parent_ip, device_id, position, nickname, model, updated_at FROM child_devices WHERE parent_ip = ? ORDER BY position",
+		parentIP,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var children []ChildDevice
+	for rows.Next() {
+		var c ChildDevice
+		var ts string
+		if err := rows.Scan(&c.Key, &c.ParentIP, &c.DeviceID, &c.Position, &c.Nickname, &c.Model, &ts); err != nil {
+			return nil, err
+		}
+		c.UpdatedAt, _ = time.Parse(time.RFC3339, ts)
+		children = append(children, c)
+	}
+	return children, rows.Err()
+}
+
+// InsertAction records an automation action in the audit log.
+func (s *Store) InsertAction(a ActionRecord) error {
+	_, err := s.db.Exec(
+		"INSERT INTO actions (timestamp, rule, device_ip, action, reason, dry_run, error) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		a.Timestamp.UTC(), a.Rule, a.DeviceIP, a.Action, a.Reason, a.DryRun, a.Error,
+	)
+	return err
+}
+
+// GetActionsSince returns audit log entries since the given time, oldest first.
+// If deviceIP is empty, returns entries for all devices.
+func (s *Store) GetActionsSince(deviceIP string, since time.Time) ([]ActionRecord, error) {
+	query := "SELECT id, timestamp, rule, device_ip, action, reason, dry_run, error FROM actions WHERE timestamp >= ?"
+	args := []interface{}{since.UTC()}
+	if deviceIP != "" {
+		query += " AND device_ip = ?"
+		args = append(args, deviceIP)
+	}
+	query += " ORDER BY timestamp"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []ActionRecord
+	for rows.Next() {
+		var r ActionRecord
+		var ts string
+		if err := rows.Scan(&r.ID, &ts, &r.Rule, &r.DeviceIP, &r.Action, &r.Reason, &r.DryRun, &r.Error); err != nil {
+			return nil, err
+		}
+		r.Timestamp, _ = time.Parse(time.RFC3339, ts)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// InsertEvent records a device state change.
+func (s *Store) InsertEvent(e EventRecord) error {
+	_, err := s.db.Exec(
+		"INSERT INTO events (timestamp, device_ip, device_mac, kind, old_value, new_value) VALUES (?, ?, ?, ?, ?, ?)",
+		e.Timestamp.UTC(), e.DeviceIP, e.DeviceMAC, e.Kind, e.OldValue, e.NewValue,
+	)
+	return err
+}
+
+// GetEventsSince returns device state changes since the given time, oldest
+// first. Events match if they have deviceIP or deviceMAC; if both are empty,
+// returns events for all devices.
+func (s *Store) GetEventsSince(deviceIP, deviceMAC string, since time.Time) ([]EventRecord, error) {
+	query := "SELECT id, timestamp, device_ip, device_mac, kind, old_value, new_value FROM events WHERE timestamp >= ?"
+	args := []interface{}{since.UTC()}
+	if deviceIP != "" || deviceMAC != "" {
+		query += " AND (device_ip = ? OR (device_mac != '' AND device_mac = ? COLLATE NOCASE))"
+		args = append(args, deviceIP, deviceMAC)
+	}
+	query += " ORDER BY timestamp, id"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []EventRecord
+	for rows.Next() {
+		var e EventRecord
+		var ts string
+		if err := rows.Scan(&e.ID, &ts, &e.DeviceIP, &e.DeviceMAC, &e.Kind, &e.OldValue, &e.NewValue); err != nil {
+			return nil, err
+		}
+		e.Timestamp, _ = time.Parse(time.RFC3339, ts)
+		records = append(records, e)
+	}
+	return records, rows.Err()
+}
+
+// InsertHealth records a device's health at one poll.
+func (s *Store) InsertHealth(h HealthRecord) error {
+	var readingID sql.NullInt64
+	if h.ReadingID != 0 {
+		readingID = sql.NullInt64{Int64: h.ReadingID, Valid: true}
+	}
+	_, err := s.db.Exec(
+		`INSERT INTO device_health (timestamp, device_ip, device_mac, reading_id, rssi, signal_level,
+			on_time_s, overheated, power_protection, firmware, latency_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		h.Timestamp.UTC(), h.DeviceIP, h.DeviceMAC, readingID, h.RSSI, h.SignalLevel,
+		h.OnTimeS, h.OverHeated, h.PowerProtection, h.Firmware, h.LatencyMS,
+	)
+	return err
+}
+
+const healthColumns = `id, timestamp, device_ip, device_mac, COALESCE(reading_id, 0), rssi, signal_level,
+	on_time_s, overheated, power_protection, firmware, latency_ms`
+
+// GetHealthRange returns health records within a time range, oldest first.
+// If deviceIP is empty, returns records for all devices.
+func (s *Store) GetHealthRange(deviceIP string, start, end time.Time) ([]HealthRecord, error) {
+	query := "SELECT " + healthColumns + " FROM device_health WHERE timestamp >= ? AND timestamp <= ?"
+	args := []interface{}{start.UTC(), end.UTC()}
+	if deviceIP != "" {
+		query += " AND device_ip = ?"
+		args = append(args, deviceIP)
+	}
+	query += " ORDER BY timestamp, id"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanHealth(rows)
+}
+
+// GetHealthForReadings returns the health records linked to readings with
+// IDs in (afterID, lastID], keyed by reading ID.
+func (s *Store) GetHealthForReadings(afterID, lastID int64) (map[int64]HealthRecord, error) {
+	rows, err := s.db.Query(
+		"SELECT "+healthColumns+" FROM device_health WHERE reading_id > ? AND reading_id <= ?",
+		afterID, lastID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	records, err := scanHealth(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	byReading := make(map[int64]HealthRecord, len(records))
+	for _, h := range records {
+		byReading[h.ReadingID] = h
+	}
+	return byReading, nil
+}
+
+// scanHealth reads all rows of a device_health query and closes them.
+func scanHealth(rows *sql.Rows) ([]HealthRecord, error) {
+	defer rows.Close()
+
+	var records []HealthRecord
+	for rows.Next() {
+		var h HealthRecord
+		var ts string
+		if err := rows.Scan(&h.ID, &ts, &h.DeviceIP, &h.DeviceMAC, &h.ReadingID, &h.RSSI, &h.SignalLevel,
+			&h.OnTimeS, &h.OverHeated, &h.PowerProtection, &h.Firmware, &h.LatencyMS); err != nil {
+			return nil, err
+		}
+		h.Timestamp, _ = time.Parse(time.RFC3339, ts)
+		records = append(records, h)
+	}
+	return records, rows.Err()
+}
+
+// GetDeviceIPByMAC returns the IP most recently recorded for a MAC address.
+func (s *Store) GetDeviceIPByMAC(mac string) (string, error) {
+	var ip string
+	err := s.db.QueryRow(
+		"SELECT device_ip FROM readings WHERE device_mac = ? COLLATE NOCASE ORDER BY id DESC LIMIT 1",
+		mac,
+	).Scan(&ip)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return ip, err
+}
+
 // GetLatestReading returns the most recent reading for a device.
 func (s *Store) GetLatestReading(deviceIP string) (*Reading, error) {
 	row := s.db.QueryRow(
-		"SELECT id, timestamp, device_ip, device_mac, power_mw FROM readings WHERE device_ip = ? ORDER BY timestamp DESC LIMIT 1",
+		"SELECT id, timestamp, device_ip, device_mac, power_mw, device_on FROM readings WHERE device_ip = ? ORDER BY timestamp DESC LIMIT 1",
 		deviceIP,
 	)
 
 	var r Reading
 	var ts string
-	err := row.Scan(&r.ID, &ts, &r.DeviceIP, &r.DeviceMAC, &r.PowerMW)
+	err := row.Scan(&r.ID, &ts, &r.DeviceIP, &r.DeviceMAC, &r.PowerMW, &r.DeviceOn)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -181,19 +599,37 @@ func (s *Store) GetLatestReading(deviceIP string) (*Reading, error) {
 // GetReadingsRange returns readings within a time range.
 func (s *Store) GetReadingsRange(deviceIP string, start, end time.Time) ([]Reading, error) {
 	rows, err := s.db.Query(
-		"SELECT id, timestamp, device_ip, device_mac, power_mw FROM readings WHERE device_ip = ? AND timestamp >= ? AND timestamp <= ? ORDER BY timestamp",
+		"SELECT id, timestamp, device_ip, device_mac, power_mw, device_on FROM readings WHERE device_ip = ? AND timestamp >= ? AND timestamp <= ? ORDER BY timestamp",
 		deviceIP, start.UTC(), end.UTC(),
 	)
 	if err != nil {
 		return nil, err
 	}
+	return scanReadings(rows)
+}
+
+// GetReadingsAfter returns up to limit readings with an ID greater than afterID,
+// oldest first. It is used to replay readings a stream subscriber missed.
+func (s *Store) GetReadingsAfter(afterID int64, limit int) ([]Reading, error) {
+	rows, err := s.db.Query(
+		"SELECT id, timestamp, device_ip, device_mac, power_mw, device_on FROM readings WHERE id > ? ORDER BY id LIMIT ?",
+		afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanReadings(rows)
+}
+
+// scanReadings reads all rows of a readings query and closes them.
+func scanReadings(rows *sql.Rows) ([]Reading, error) {
 	defer rows.Close()
 
 	var readings []Reading
 	for rows.Next() {
 		var r Reading
 		var ts string
-		if err := rows.Scan(&r.ID, &ts, &r.DeviceIP, &r.DeviceMAC, &r.PowerMW); err != nil {
+		if err := rows.Scan(&r.ID, &ts, &r.DeviceIP, &r.DeviceMAC, &r.PowerMW, &r.DeviceOn); err != nil {
 			return nil, err
 		}
 		r.Timestamp, _ = time.Parse(time.RFC3339, ts)
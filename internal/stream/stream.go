@@ -0,0 +1,249 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abhishek/p110/internal/store"
+)
+
+const (
+	subscriberBuffer  = 64
+	replayBatchSize   = 500
+	keepAliveInterval = 30 * time.Second
+)
+
+// Event is a single power reading pushed to stream subscribers.
+type Event struct {
+	ID        int64     `json:"id"`
+	DeviceIP  string    `json:"device_ip"`
+	DeviceMAC string    `json:"device_mac,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	PowerW    float64   `json:"power_w"`
+	DeviceOn  bool      `json:"device_on"`
+	Health    *Health   `json:"health,omitempty"`
+}
+
+// Health is the device's Wi-Fi and protection state at the poll that took
+// the reading.
+type Health struct {
+	RSSI            int    `json:"rssi"`
+	SignalLevel     int    `json:"signal_level"`
+	OnTimeS         int    `json:"on_time_s"`
+	OverHeated      bool   `json:"overheated"`
+	PowerProtection string `json:"power_protection,omitempty"`
+	Firmware        string `json:"firmware,omitempty"`
+	LatencyMS       int    `json:"latency_ms"`
+}
+
+// HealthFromRecord converts a stored health record for an event.
+func HealthFromRecord(h store.HealthRecord) *Health {
+	return &Health{
+		RSSI:            h.RSSI,
+		SignalLevel:     h.SignalLevel,
+		OnTimeS:         h.OnTimeS,
+		OverHeated:      h.OverHeated,
+		PowerProtection: h.PowerProtection,
+		Firmware:        h.Firmware,
+		LatencyMS:       h.LatencyMS,
+	}
+}
+
+// EventFromReading converts a stored reading into a stream event.
+func EventFromReading(r store.Reading) Event {
+	return Event{
+		ID:        r.ID,
+		DeviceIP:  r.DeviceIP,
+		DeviceMAC: r.DeviceMAC,
+		Timestamp: r.Timestamp,
+		PowerW:    float64(r.PowerMW) / 1000.0,
+		DeviceOn:  r.DeviceOn,
+	}
+}
+
+// matches reports whether the event belongs to one of the given devices.
+// An empty filter matches every device.
+func (e Event) matches(devices []string) bool {
+	if len(devices) == 0 {
+		return true
+	}
+	for _, d := range devices {
+		if strings.EqualFold(d, e.DeviceIP) || (e.DeviceMAC != "" && strings.EqualFold(d, e.DeviceMAC)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Hub fans out events from the daemon's poll loop to live subscribers.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// NewHub creates an empty hub.
+func NewHub() *Hub {
+	return &Hub{subs: make(map[chan Event]struct{})}
+}
+
+// Publish sends an event to every subscriber. Subscribers that are too slow
+// to keep up miss the event; they can catch up with Last-Event-ID.
+func (h *Hub) Publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe registers a new subscriber. The returned function unsubscribes it.
+func (h *Hub) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+}
+
+// Handler serves the live power stream as Server-Sent Events.
+//
+// Clients may filter with one or more ?device= parameters (IP or MAC, also
+// comma-separated) and resume from a reading ID with the Last-Event-ID header
+// or the ?last_event_id= parameter. Missed readings are replayed from the
+// readings table, with their device_health records, before live events are
+// sent.
+type Handler struct {
+	hub *Hub
+	db  *store.Store
+}
+
+// NewHandler creates a stream handler backed by the hub and, for replay, the store.
+func NewHandler(hub *Hub, db *store.Store) *Handler {
+	return &Handler{hub: hub, db: db}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	var devices []string
+	for _, v := range r.URL.Query()["device"] {
+		for _, d := range strings.Split(v, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				devices = append(devices, d)
+			}
+		}
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var afterID int64
+	if lastID != "" {
+		id, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		afterID = id
+	}
+
+	// Subscribe before replaying so nothing published in between is lost.
+	events, unsubscribe := h.hub.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	if afterID > 0 && h.db != nil {
+		for {
+			readings, err := h.db.GetReadingsAfter(afterID, replayBatchSize)
+			if err != nil {
+				log.Printf("Stream replay failed: %v", err)
+				return
+			}
+			var health map[int64]store.HealthRecord
+			if len(readings) > 0 {
+				health, err = h.db.GetHealthForReadings(afterID, readings[len(readings)-1].ID)
+				if err != nil {
+					log.Printf("Stream replay failed: %v", err)
+					return
+				}
+			}
+			for _, reading := range readings {
+				e := EventFromReading(reading)
+				if rec, ok := health[reading.ID]; ok {
+					e.Health = HealthFromRecord(rec)
+				}
+				if e.matches(devices) {
+					if err := writeEvent(w, e); err != nil {
+						return
+					}
+				}
+				afterID = reading.ID
+			}
+			flusher.Flush()
+			if len(readings) < replayBatchSize {
+				break
+			}
+		}
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case e := <-events:
+			if e.ID <= afterID || !e.matches(devices) {
+				continue
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+			afterID = e.ID
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes a single SSE message for the event.
+func writeEvent(w http.ResponseWriter, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: reading\ndata: %s\n\n", e.ID, data)
+	return err
+}
@@ -0,0 +1,129 @@
+package tapo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotSupported is returned by methods the device lacks the capability for.
+var ErrNotSupported = errors.New("not supported by this device")
+
+// Capabilities lists what a device supports beyond device info and on/off,
+// which every Tapo plug, strip and bulb has.
+type Capabilities struct {
+	Energy     bool `json:"energy"`     // current power and energy history
+	Brightness bool `json:"brightness"` // dimmable light
+	Color      bool `json:"color"`      // hue, saturation and colour temperature
+	Children   bool `json:"children"`   // outlets reached through Strip
+}
+
+// energyModels meter energy. The P304M and P316M meter per outlet; the
+// Kasa KP115, KP125 and HS110 speak the legacy protocol over KLAP.
+var energyModels = []string{"P110", "P115", "P125M", "KP125M", "P304M", "P316M", "KP115", "KP125", "HS110"}
+
+// colorModels are colour bulbs and light strips.
+var colorModels = []string{"L530", "L535", "L630", "L900", "L920", "L930"}
+
+func hasModelPrefix(model string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(model, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectCapabilities derives capabilities from the model and device type
+// reported by get_device_info or discovery (e.g. "SMART.TAPOBULB").
+func DetectCapabilities(model, deviceType string) Capabilities {
+	model = strings.ToUpper(model)
+	switch {
+	case strings.Contains(strings.ToUpper(deviceType), "BULB") || strings.HasPrefix(model, "L"):
+		return Capabilities{Brightness: true, Color: hasModelPrefix(model, colorModels)}
+	case IsStrip(model):
+		return Capabilities{Children: true}
+	default:
+		return Capabilities{Energy: hasModelPrefix(model, energyModels)}
+	}
+}
+
+// Capabilities returns what the outlet supports. Outlets of metering strips
+// report energy; others only switch.
+func (c ChildInfo) Capabilities() Capabilities {
+	return Capabilities{Energy: hasModelPrefix(strings.ToUpper(c.Model), energyModels)}
+}
+
+// Capabilities fetches device info, if it hasn't been fetched on this
+// connection yet, and returns what the device supports.
+func (p *P110) Capabilities() (Capabilities, error) {
+	if caps := p.cachedCapabilities(); caps != nil {
+		return *caps, nil
+	}
+	if _, err := p.GetDeviceInfo(); err != nil {
+		return Capabilities{}, err
+	}
+	return *p.cachedCapabilities(), nil
+}
+
+func (p *P110) cachedCapabilities() *Capabilities {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.caps
+}
+
+func (p *P110) setCapabilities(info *DeviceInfo) {
+	caps := DetectCapabilities(info.Model, info.Type)
+	p.mu.Lock()
+	p.caps = &caps
+	p.mu.Unlock()
+}
+
+// require returns ErrNotSupported if the device is known to lack a
+// capability. Before device info has been fetched, every call is attempted.
+func (p *P110) require(name string, has func(Capabilities) bool) error {
+	if caps := p.cachedCapabilities(); caps != nil && !has(*caps) {
+		return fmt.Errorf("%s: %w", name, ErrNotSupported)
+	}
+	return nil
+}
+
+func hasEnergy(c Capabilities) bool     { return c.Energy }
+func hasBrightness(c Capabilities) bool { return c.Brightness }
+func hasColor(c Capabilities) bool      { return c.Color }
+
+// SetBrightness sets a bulb's brightness from 1 to 100 percent.
+func (p *P110) SetBrightness(percent int) error {
+	if err := p.require("brightness", hasBrightness); err != nil {
+		return err
+	}
+	if percent < 1 || percent > 100 {
+		return fmt.Errorf("brightness must be 1-100, got %d", percent)
+	}
+	_, err := p.sendRequest("set_device_info", map[string]int{"brightness": percent})
+	return err
+}
+
+// SetColor sets a colour bulb's hue (0-360) and saturation (0-100).
+func (p *P110) SetColor(hue, saturation int) error {
+	if err := p.require("color", hasColor); err != nil {
+		return err
+	}
+	if hue < 0 || hue > 360 || saturation < 0 || saturation > 100 {
+		return fmt.Errorf("hue must be 0-360 and saturation 0-100, got %d/%d", hue, saturation)
+	}
+	_, err := p.sendRequest("set_device_info", map[string]int{"hue": hue, "saturation": saturation, "color_temp": 0})
+	return err
+}
+
+// SetColorTemperature sets a colour bulb's white temperature in kelvin.
+func (p *P110) SetColorTemperature(kelvin int) error {
+	if err := p.require("color temperature", hasColor); err != nil {
+		return err
+	}
+	if kelvin < 2500 || kelvin > 6500 {
+		return fmt.Errorf("colour temperature must be 2500-6500 K, got %d", kelvin)
+	}
+	_, err := p.sendRequest("set_device_info", map[string]int{"color_temp": kelvin})
+	return err
+}
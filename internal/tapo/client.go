@@ -2,40 +2,108 @@ package tapo
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
 
-// Client is the main client for communicating with Tapo devices.
+// Client is the main client for communicating with Tapo devices. It keeps
+// only the hashes KLAP authenticates with, never the plaintext password.
 type Client struct {
-	username string
-	password string
+	authHash   []byte
+	authHashV1 []byte // nil when created from an auth hash
+
+	mu        sync.Mutex
+	endpoints map[string]endpoint
+	accounts  map[string]*Client // devices registered to another account
+}
+
+// endpoint is where a device serves KLAP, as advertised in discovery.
+type endpoint struct {
+	port  int
+	https bool
 }
 
 // NewClient creates a new Tapo API client.
 func NewClient(username, password string) *Client {
 	return &Client{
-		username: username,
-		password: password,
+		authHash:   generateAuthHash(username, password),
+		authHashV1: generateAuthHashV1(username, password),
+	}
+}
+
+// AuthHash returns, hex-encoded, the credential KLAP derives from a Tapo
+// account. It lets a client talk to the account's devices without the
+// password, which it doesn't reveal.
+func AuthHash(username, password string) string {
+	return hex.EncodeToString(generateAuthHash(username, password))
+}
+
+// NewClientWithAuthHash creates a client from an AuthHash, so the account
+// password need not be stored anywhere. Kasa plugs speaking KLAP v1 hash
+// the password differently and can't be reached this way.
+func NewClientWithAuthHash(authHash string) (*Client, error) {
+	hash, err := hex.DecodeString(authHash)
+	if err != nil || len(hash) != 32 {
+		return nil, fmt.Errorf("invalid auth hash: want 64 hex digits")
 	}
+	return &Client{authHash: hash}, nil
 }
 
-// P110 represents a connection to a P110/P115 smart plug.
+// P110 represents a connection to a Tapo device. Despite the name it is the
+// base device for every model: info and on/off work everywhere, while energy,
+// brightness and colour methods depend on Capabilities.
 type P110 struct {
 	client       *Client
 	ip           string
 	session      *klapSession
 	terminalUUID string
 	mu           sync.Mutex
+	caps         *Capabilities // set once device info has been fetched
+	iot          bool          // Kasa device speaking the legacy IOT protocol
 }
 
-// Connect establishes a connection to a P110/P115 device.
+// SetEndpoint records the HTTP port and scheme a device serves KLAP on, from
+// the http_port and is_support_https fields of its discovery reply, for
+// later calls to Connect. Port 0 means the default port.
+func (c *Client) SetEndpoint(ip string, port int, https bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.endpoints == nil {
+		c.endpoints = make(map[string]endpoint)
+	}
+	c.endpoints[ip] = endpoint{port: port, https: https}
+}
+
+// SetCredentials makes Connect authenticate to ip with the credentials of
+// account, for devices registered to a different Tapo account.
+func (c *Client) SetCredentials(ip string, account *Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.accounts == nil {
+		c.accounts = make(map[string]*Client)
+	}
+	c.accounts[ip] = account
+}
+
+// Connect establishes a connection to a Tapo device, or a Kasa device with
+// KLAP firmware.
 func (c *Client) Connect(ip string) (*P110, error) {
-	session, err := newKlapSession(ip, c.username, c.password)
+	c.mu.Lock()
+	ep := c.endpoints[ip]
+	creds := c
+	if account := c.accounts[ip]; account != nil {
+		creds = account
+	}
+	c.mu.Unlock()
+
+	session, err := newKlapSession(ip, ep.port, ep.https, creds.authHash, creds.authHashV1)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create session: %w", err)
 	}
@@ -49,6 +117,7 @@ func (c *Client) Connect(ip string) (*P110, error) {
 		ip:           ip,
 		session:      session,
 		terminalUUID: uuid.New().String(),
+		iot:          session.legacy,
 	}, nil
 }
 
@@ -59,6 +128,7 @@ func (c *Client) ConnectWithDiscovery(ctx context.Context) (*P110, string, error
 		return nil, "", fmt.Errorf("discovery failed: %w", err)
 	}
 
+	c.SetEndpoint(device.IP, device.HTTPPort, device.HTTPS)
 	p110, err := c.Connect(device.IP)
 	if err != nil {
 		return nil, device.IP, fmt.Errorf("connection failed: %w", err)
@@ -67,8 +137,44 @@ func (c *Client) ConnectWithDiscovery(ctx context.Context) (*P110, string, error
 	return p110, device.IP, nil
 }
 
+// DeviceError is a non-zero error_code returned by the device.
+type DeviceError struct {
+	Code int
+}
+
+func (e *DeviceError) Error() string {
+	return fmt.Sprintf("device returned error code: %d", e.Code)
+}
+
+// Call sends any method to the device and decodes the result into out,
+// which may be nil to discard it or a *json.RawMessage to keep it as is.
+// params may be nil for methods that take none. A non-zero error_code is
+// returned as a *DeviceError.
+func (p *P110) Call(ctx context.Context, method string, params, out interface{}) error {
+	result, err := p.sendRequestContext(ctx, method, params)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(result, out); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", method, err)
+	}
+	return nil
+}
+
 // sendRequest sends a request to the device and returns the response.
 func (p *P110) sendRequest(method string, params interface{}) (json.RawMessage, error) {
+	return p.sendRequestContext(context.Background(), method, params)
+}
+
+// sendRequestContext is sendRequest with a context for the HTTP call.
+func (p *P110) sendRequestContext(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	if p.iot {
+		return nil, fmt.Errorf("%s: %w", method, ErrNotSupported)
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -85,7 +191,7 @@ func (p *P110) sendRequest(method string, params interface{}) (json.RawMessage,
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	respJSON, err := p.session.request(reqJSON)
+	respJSON, err := p.session.request(ctx, reqJSON)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
@@ -96,7 +202,7 @@ func (p *P110) sendRequest(method string, params interface{}) (json.RawMessage,
 	}
 
 	if resp.ErrorCode != 0 {
-		return nil, fmt.Errorf("device returned error code: %d", resp.ErrorCode)
+		return nil, &DeviceError{Code: resp.ErrorCode}
 	}
 
 	return resp.Result, nil
@@ -104,6 +210,9 @@ func (p *P110) sendRequest(method string, params interface{}) (json.RawMessage,
 
 // GetDeviceInfo retrieves device information.
 func (p *P110) GetDeviceInfo() (*DeviceInfo, error) {
+	if p.iot {
+		return p.iotDeviceInfo()
+	}
 	result, err := p.sendRequest("get_device_info", nil)
 	if err != nil {
 		return nil, err
@@ -113,10 +222,23 @@ func (p *P110) GetDeviceInfo() (*DeviceInfo, error) {
 	if err := json.Unmarshal(result, &info); err != nil {
 		return nil, fmt.Errorf("failed to parse device info: %w", err)
 	}
+	info.Nickname = decodeBase64(info.Nickname)
+	info.SSID = decodeBase64(info.SSID)
+	p.setCapabilities(&info)
 
 	return &info, nil
 }
 
+// decodeBase64 decodes the base64 text fields KLAP devices report, such as
+// nickname and ssid. Values that aren't valid base64 text are returned as is.
+func decodeBase64(s string) string {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || !utf8.Valid(b) {
+		return s
+	}
+	return string(b)
+}
+
 // GetDeviceUsage retrieves device usage statistics.
 func (p *P110) GetDeviceUsage() (*DeviceUsage, error) {
 	result, err := p.sendRequest("get_device_usage", nil)
@@ -134,6 +256,12 @@ func (p *P110) GetDeviceUsage() (*DeviceUsage, error) {
 
 // GetCurrentPower retrieves the current power consumption.
 func (p *P110) GetCurrentPower() (*CurrentPower, error) {
+	if err := p.require("get_current_power", hasEnergy); err != nil {
+		return nil, err
+	}
+	if p.iot {
+		return p.iotCurrentPower()
+	}
 	result, err := p.sendRequest("get_current_power", nil)
 	if err != nil {
 		return nil, err
@@ -149,6 +277,12 @@ func (p *P110) GetCurrentPower() (*CurrentPower, error) {
 
 // GetEnergyUsage retrieves energy usage data.
 func (p *P110) GetEnergyUsage() (*EnergyUsage, error) {
+	if err := p.require("get_energy_usage", hasEnergy); err != nil {
+		return nil, err
+	}
+	if p.iot {
+		return p.iotEnergyUsage()
+	}
 	result, err := p.sendRequest("get_energy_usage", nil)
 	if err != nil {
 		return nil, err
@@ -164,6 +298,32 @@ func (p *P110) GetEnergyUsage() (*EnergyUsage, error) {
 
 // GetEnergyData retrieves energy data for the specified interval.
 func (p *P110) GetEnergyData(interval EnergyDataInterval, t time.Time) (*EnergyData, error) {
+	if err := p.require("get_energy_data", hasEnergy); err != nil {
+		return nil, err
+	}
+	if p.iot {
+		return p.iotEnergyData(interval, t)
+	}
+	params, err := newEnergyDataParams(interval, t)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := p.sendRequest("get_energy_data", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var data EnergyData
+	if err := json.Unmarshal(result, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse energy data: %w", err)
+	}
+
+	return &data, nil
+}
+
+// newEnergyDataParams builds the get_energy_data request for an interval.
+func newEnergyDataParams(interval EnergyDataInterval, t time.Time) (energyDataParams, error) {
 	startTS, endTS := getStartEndTimestamps(interval, t)
 
 	var intervalMinutes int
@@ -175,36 +335,30 @@ func (p *P110) GetEnergyData(interval EnergyDataInterval, t time.Time) (*EnergyD
 	case EnergyDataMonthly:
 		intervalMinutes = IntervalMonthly
 	default:
-		return nil, fmt.Errorf("invalid interval: %s", interval)
+		return energyDataParams{}, fmt.Errorf("invalid interval: %s", interval)
 	}
 
-	params := energyDataParams{
+	return energyDataParams{
 		StartTimestamp: startTS,
 		EndTimestamp:   endTS,
 		Interval:       intervalMinutes,
-	}
-
-	result, err := p.sendRequest("get_energy_data", params)
-	if err != nil {
-		return nil, err
-	}
-
-	var data EnergyData
-	if err := json.Unmarshal(result, &data); err != nil {
-		return nil, fmt.Errorf("failed to parse energy data: %w", err)
-	}
-
-	return &data, nil
+	}, nil
 }
 
 // TurnOn turns the device on.
 func (p *P110) TurnOn() error {
+	if p.iot {
+		return p.iotSetRelay(true)
+	}
 	_, err := p.sendRequest("set_device_info", map[string]bool{"device_on": true})
 	return err
 }
 
 // TurnOff turns the device off.
 func (p *P110) TurnOff() error {
+	if p.iot {
+		return p.iotSetRelay(false)
+	}
 	_, err := p.sendRequest("set_device_info", map[string]bool{"device_on": false})
 	return err
 }
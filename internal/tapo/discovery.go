@@ -2,10 +2,13 @@ package tapo
 
 import (
 	"context"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -13,193 +16,608 @@ const (
 	discoveryPort    = 20002
 	discoveryMagic   = "020000010000000000000000463cb5d3"
 	discoveryTimeout = 5 * time.Second
+
+	// Kasa devices answer an XOR-obfuscated get_sysinfo on port 9999.
+	kasaDiscoveryPort = 9999
+	kasaSysinfo       = `{"system":{"get_sysinfo":{}}}`
+
+	// Probes are retransmitted with exponential backoff starting at
+	// retransmitDelay, since either the probe or the reply is easily lost
+	// on congested Wi-Fi.
+	discoveryRetries = 3
+	retransmitDelay  = 250 * time.Millisecond
+
+	// Unicast scans send probes in bursts so large ranges don't overrun
+	// the socket buffer or the network.
+	scanBurst    = 64
+	scanPause    = 5 * time.Millisecond
+	scanMaxHosts = 1 << 16
 )
 
-// Discover finds Tapo devices on the local network using UDP broadcast.
-// It returns a slice of discovered devices.
-func Discover(ctx context.Context) ([]DiscoveredDevice, error) {
-	return DiscoverWithTimeout(ctx, discoveryTimeout)
+// Discoverer finds Tapo devices on the local network using UDP broadcast.
+// Devices are reported as each reply arrives; the First, All and Until
+// helpers collect them for callers that just want a list.
+type Discoverer struct {
+	Timeout time.Duration // how long to wait for replies
+
+	// Interfaces limits the probe to the named network interfaces, and
+	// Subnets to directed broadcasts on CIDRs such as 192.168.50.0/24.
+	// When both are empty every up, broadcast-capable IPv4 interface is
+	// probed.
+	Interfaces []string
+	Subnets    []string
+
+	// Scan sends the probe by unicast to every address in these CIDRs, for
+	// networks that broadcasts don't reach, such as a routed IoT VLAN.
+	// It adds to the broadcasts rather than replacing them.
+	Scan []string
+
+	// Retries is how many times each probe is retransmitted within the
+	// timeout, with the delay doubling each time.
+	Retries int
+
+	// Kasa also sends the legacy Kasa probe on port 9999, to find Kasa
+	// plugs such as the KP115 that don't answer Tapo discovery.
+	Kasa bool
 }
 
-// DiscoverWithTimeout finds Tapo devices with a custom timeout.
-func DiscoverWithTimeout(ctx context.Context, timeout time.Duration) ([]DiscoveredDevice, error) {
+// NewDiscoverer returns a Discoverer that listens for replies for timeout.
+func NewDiscoverer(timeout time.Duration) *Discoverer {
+	return &Discoverer{Timeout: timeout, Retries: discoveryRetries}
+}
+
+// probe is one directed broadcast, or a unicast scan, sent from a socket
+// bound to local so it leaves through the right interface and replies can
+// be tagged with it.
+type probe struct {
+	iface string
+	local net.IP // nil for an unbound socket
+	dsts  []net.IP
+	kasa  bool // legacy Kasa probe instead of Tapo's
+}
+
+// discoveryProtocol is how to send a probe and read its replies.
+type discoveryProtocol struct {
+	port    int
+	payload []byte
+	parse   func(data []byte, addr *net.UDPAddr) (DiscoveredDevice, bool)
+}
+
+// Run broadcasts a discovery probe on each selected interface, scans any
+// Scan ranges, and calls found for each device that replies, once per
+// device ID. It returns when the timeout expires, ctx is done or found
+// returns false. Reaching ctx's deadline ends discovery like the timeout
+// does; only cancelling ctx is reported as an error.
+func (d *Discoverer) Run(ctx context.Context, found func(DiscoveredDevice) bool) error {
+	return d.run(ctx, func(device DiscoveredDevice, first bool) bool {
+		return !first || found(device)
+	})
+}
+
+// run is Run, but calls reply for every reply, with first set on the first
+// one from each device.
+func (d *Discoverer) run(ctx context.Context, reply func(device DiscoveredDevice, first bool) bool) error {
 	payload, err := hex.DecodeString(discoveryMagic)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode discovery payload: %w", err)
+		return fmt.Errorf("failed to decode discovery payload: %w", err)
 	}
+	tapoProto := discoveryProtocol{discoveryPort, payload, parseDiscoveryResponse}
+	kasaProto := discoveryProtocol{kasaDiscoveryPort, kasaEncrypt([]byte(kasaSysinfo)), parseKasaResponse}
 
-	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: 0})
+	probes, err := d.probes()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create UDP socket: %w", err)
+		return err
 	}
-	defer conn.Close()
 
-	broadcastAddr := &net.UDPAddr{
-		IP:   net.IPv4bcast,
-		Port: discoveryPort,
-	}
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, d.Timeout)
+	defer cancel()
+
+	replies := make(chan DiscoveredDevice)
+	var wg sync.WaitGroup
+	var lastErr error
+	sent := 0
+	for _, pr := range probes {
+		proto := tapoProto
+		if pr.kasa {
+			proto = kasaProto
+		}
 
-	if err := conn.SetWriteDeadline(time.Now().Add(time.Second)); err != nil {
-		return nil, fmt.Errorf("failed to set write deadline: %w", err)
-	}
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: pr.local})
+		if err != nil {
+			lastErr = fmt.Errorf("failed to create UDP socket: %w", err)
+			continue
+		}
+		defer conn.Close()
 
-	_, err = conn.WriteToUDP(payload, broadcastAddr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send discovery broadcast: %w", err)
+		if err := conn.SetWriteDeadline(time.Now().Add(time.Second)); err != nil {
+			lastErr = fmt.Errorf("failed to set write deadline: %w", err)
+			continue
+		}
+		if _, err := conn.WriteToUDP(proto.payload, &net.UDPAddr{IP: pr.dsts[0], Port: proto.port}); err != nil {
+			lastErr = fmt.Errorf("failed to send discovery probe to %s: %w", pr.dsts[0], err)
+			continue
+		}
+
+		sent++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			receive(ctx, conn, pr.iface, proto, replies)
+		}()
+		go func() {
+			scan(ctx, conn, proto, pr.dsts[1:])
+			retransmit(ctx, conn, proto, pr.dsts, d.Retries)
+		}()
+	}
+	// Only fail if no probe could be sent at all.
+	if sent == 0 {
+		return lastErr
 	}
+	go func() {
+		wg.Wait()
+		close(replies)
+	}()
 
-	devices := make([]DiscoveredDevice, 0)
 	seen := make(map[string]bool)
+	for device := range replies {
+		key := discoveryKey(device)
+		first := !seen[key]
+		seen[key] = true
+		device.Answers = 1
+
+		if !reply(device, first) {
+			return nil
+		}
+	}
+	if err := parent.Err(); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return nil
+}
 
-	deadline := time.Now().Add(timeout)
+// receive reads discovery replies from conn until ctx is done, sending each
+// device on replies tagged with iface.
+func receive(ctx context.Context, conn *net.UDPConn, iface string, proto discoveryProtocol, replies chan<- DiscoveredDevice) {
+	// Unblock the read as soon as ctx is done.
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	buf := make([]byte, 2048)
 	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) || (errors.As(err, &netErr) && netErr.Timeout()) {
+				return
+			}
+			continue
+		}
+
+		device, ok := proto.parse(buf[:n], addr)
+		if !ok {
+			continue
+		}
+		device.Interface = iface
+
 		select {
+		case replies <- device:
 		case <-ctx.Done():
-			return devices, ctx.Err()
-		default:
+			return
 		}
+	}
+}
 
-		remaining := time.Until(deadline)
-		if remaining <= 0 {
-			break
+// retransmit resends the probe to dsts retries times, doubling the delay
+// each time, until ctx is done.
+func retransmit(ctx context.Context, conn *net.UDPConn, proto discoveryProtocol, dsts []net.IP, retries int) {
+	delay := retransmitDelay
+	for range retries {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
 		}
+		scan(ctx, conn, proto, dsts)
+		delay *= 2
+	}
+}
 
-		if err := conn.SetReadDeadline(time.Now().Add(remaining)); err != nil {
-			return devices, fmt.Errorf("failed to set read deadline: %w", err)
+// scan sends the probe to each address in turn, pausing between bursts.
+// Send errors for individual hosts are ignored.
+func scan(ctx context.Context, conn *net.UDPConn, proto discoveryProtocol, dsts []net.IP) {
+	conn.SetWriteDeadline(time.Time{})
+	for i, ip := range dsts {
+		if i > 0 && i%scanBurst == 0 {
+			select {
+			case <-time.After(scanPause):
+			case <-ctx.Done():
+				return
+			}
 		}
+		conn.WriteToUDP(proto.payload, &net.UDPAddr{IP: ip, Port: proto.port})
+	}
+}
 
-		buf := make([]byte, 2048)
-		n, addr, err := conn.ReadFromUDP(buf)
+// scanDuration estimates how long a unicast scan of n hosts takes to send,
+// including each retransmit and the backoff before it.
+func scanDuration(n, retries int) time.Duration {
+	pass := time.Duration((n-1)/scanBurst) * scanPause
+	total := pass
+	delay := retransmitDelay
+	for range retries {
+		total += delay + pass
+		delay *= 2
+	}
+	return total
+}
+
+// probes returns the probes to send: a broadcast per selected interface
+// address and per subnet, or the limited broadcast if no interface
+// qualifies, plus a unicast scan of any Scan ranges. With Kasa set, each is
+// sent in both formats.
+func (d *Discoverer) probes() ([]probe, error) {
+	probes, err := d.broadcastProbes()
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []net.IP
+	for _, cidr := range d.Scan {
+		h, err := scanHosts(cidr)
 		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				break
-			}
-			continue
+			return nil, err
+		}
+		hosts = append(hosts, h...)
+	}
+	if len(hosts) > 0 {
+		if need := scanDuration(len(hosts), d.Retries); need > d.Timeout {
+			return nil, fmt.Errorf("scanning %d addresses with %d retransmits takes about %s, longer than the %s timeout; raise the timeout or scan a smaller range",
+				len(hosts), d.Retries, need.Round(100*time.Millisecond), d.Timeout)
 		}
+		probes = append(probes, probe{dsts: hosts})
+	}
 
-		if n <= 16 {
-			continue
+	if d.Kasa {
+		for _, pr := range probes {
+			pr.kasa = true
+			probes = append(probes, pr)
 		}
+	}
+	return probes, nil
+}
 
-		// Skip the 16-byte header
-		jsonData := buf[16:n]
+// broadcastProbes returns a broadcast per selected interface address and
+// per subnet, or the limited broadcast if no interface qualifies.
+func (d *Discoverer) broadcastProbes() ([]probe, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network interfaces: %w", err)
+	}
 
-		var resp discoveryResponse
-		if err := json.Unmarshal(jsonData, &resp); err != nil {
+	// addrs maps each usable interface to its IPv4 networks.
+	type ifaceAddr struct {
+		iface string
+		net   *net.IPNet
+	}
+	var addrs []ifaceAddr
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 {
 			continue
 		}
-
-		if resp.ErrorCode != 0 {
+		ifAddrs, err := ifi.Addrs()
+		if err != nil {
 			continue
 		}
+		for _, a := range ifAddrs {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				addrs = append(addrs, ifaceAddr{ifi.Name, ipNet})
+			}
+		}
+	}
+
+	var probes []probe
+	for _, name := range d.Interfaces {
+		n := 0
+		for _, a := range addrs {
+			if a.iface == name {
+				probes = append(probes, probe{iface: name, local: a.net.IP.To4(), dsts: []net.IP{directedBroadcast(a.net)}})
+				n++
+			}
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("no usable IPv4 interface named %q", name)
+		}
+	}
 
-		ip := resp.Result.IP
-		if ip == "" {
-			ip = addr.IP.String()
+	for _, cidr := range d.Subnets {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil || subnet.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid IPv4 subnet %q", cidr)
 		}
+		// Send from an address on the subnet if we have one; otherwise
+		// leave it to routing, which may forward a directed broadcast.
+		pr := probe{dsts: []net.IP{directedBroadcast(subnet)}}
+		for _, a := range addrs {
+			if subnet.Contains(a.net.IP) {
+				pr.iface, pr.local = a.iface, a.net.IP.To4()
+				break
+			}
+		}
+		probes = append(probes, pr)
+	}
 
-		if seen[ip] {
+	if len(d.Interfaces) > 0 || len(d.Subnets) > 0 {
+		return probes, nil
+	}
+
+	for _, a := range addrs {
+		// Point-to-point links such as most VPNs have no broadcast address.
+		if ones, bits := a.net.Mask.Size(); ones >= bits-1 {
 			continue
 		}
-		seen[ip] = true
+		probes = append(probes, probe{iface: a.iface, local: a.net.IP.To4(), dsts: []net.IP{directedBroadcast(a.net)}})
+	}
+	if len(probes) == 0 {
+		probes = append(probes, probe{dsts: []net.IP{net.IPv4bcast}})
+	}
+	return probes, nil
+}
 
-		devices = append(devices, DiscoveredDevice{
-			IP:       ip,
-			MAC:      resp.Result.MAC,
-			DeviceID: resp.Result.DeviceID,
-			Model:    resp.Result.DeviceModel,
-		})
+// scanHosts lists the host addresses of an IPv4 CIDR, leaving out the
+// network and broadcast addresses of ranges larger than /31.
+func scanHosts(cidr string) ([]net.IP, error) {
+	if net.ParseIP(cidr) != nil {
+		cidr += "/32"
+	}
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil || subnet.IP.To4() == nil {
+		return nil, fmt.Errorf("invalid IPv4 scan range %q", cidr)
+	}
+	ones, bits := subnet.Mask.Size()
+	size := 1 << (bits - ones)
+	if size > scanMaxHosts {
+		return nil, fmt.Errorf("scan range %s is too large (at most /16)", cidr)
 	}
 
-	return devices, nil
+	first, last := 0, size-1
+	if size > 2 {
+		first, last = 1, size-2
+	}
+	base := binary.BigEndian.Uint32(subnet.IP.To4())
+	hosts := make([]net.IP, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, base+uint32(i))
+		hosts = append(hosts, ip)
+	}
+	return hosts, nil
 }
 
-// DiscoverFirst finds the first Tapo device on the network.
-// Returns an error if no device is found within the timeout.
-func DiscoverFirst(ctx context.Context) (*DiscoveredDevice, error) {
-	return DiscoverFirstWithTimeout(ctx, discoveryTimeout)
+// directedBroadcast returns the broadcast address of an IPv4 network,
+// e.g. 192.168.50.255 for 192.168.50.0/24.
+func directedBroadcast(n *net.IPNet) net.IP {
+	ip := n.IP.To4()
+	mask := n.Mask
+	if len(mask) == net.IPv6len {
+		mask = mask[12:]
+	}
+	bcast := make(net.IP, net.IPv4len)
+	for i := range bcast {
+		bcast[i] = ip[i] | ^mask[i]
+	}
+	return bcast
 }
 
-// DiscoverFirstWithTimeout finds the first device with a custom timeout.
-func DiscoverFirstWithTimeout(ctx context.Context, timeout time.Duration) (*DiscoveredDevice, error) {
-	payload, err := hex.DecodeString(discoveryMagic)
+// Stream runs discovery in the background, sending devices on the returned
+// channel as they reply. The channel is closed when discovery ends; the
+// error channel then receives the result of Run.
+func (d *Discoverer) Stream(ctx context.Context) (<-chan DiscoveredDevice, <-chan error) {
+	devices := make(chan DiscoveredDevice)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(devices)
+		errc <- d.Run(ctx, func(dev DiscoveredDevice) bool {
+			select {
+			case devices <- dev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+	return devices, errc
+}
+
+// All returns every device that replies within the timeout.
+func (d *Discoverer) All(ctx context.Context) ([]DiscoveredDevice, error) {
+	return d.Until(ctx, 0)
+}
+
+// Until returns as soon as n devices have replied, or every device that
+// replied within the timeout if fewer did. n <= 0 means no limit. Each
+// device's Answers counts the replies received before returning.
+func (d *Discoverer) Until(ctx context.Context, n int) ([]DiscoveredDevice, error) {
+	devices := make([]DiscoveredDevice, 0)
+	index := make(map[string]int)
+	err := d.run(ctx, func(dev DiscoveredDevice, first bool) bool {
+		key := discoveryKey(dev)
+		if !first {
+			devices[index[key]].Answers++
+			return true
+		}
+		index[key] = len(devices)
+		devices = append(devices, dev)
+		return n <= 0 || len(devices) < n
+	})
+	return devices, err
+}
+
+// First returns the first device to reply. Returns an error if no device
+// is found within the timeout.
+func (d *Discoverer) First(ctx context.Context) (*DiscoveredDevice, error) {
+	devices, err := d.Until(ctx, 1)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode discovery payload: %w", err)
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no Tapo devices found on the network")
 	}
+	return &devices[0], nil
+}
 
-	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: 0})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create UDP socket: %w", err)
+// discoveryKey identifies a device across replies. A device that changes
+// IP or answers on two interfaces is still one device.
+func discoveryKey(d DiscoveredDevice) string {
+	if d.DeviceID != "" {
+		return d.DeviceID
 	}
-	defer conn.Close()
+	return d.IP
+}
 
-	broadcastAddr := &net.UDPAddr{
-		IP:   net.IPv4bcast,
-		Port: discoveryPort,
+// parseDiscoveryResponse decodes a discovery reply received from addr.
+func parseDiscoveryResponse(data []byte, addr *net.UDPAddr) (DiscoveredDevice, bool) {
+	if len(data) <= 16 {
+		return DiscoveredDevice{}, false
 	}
 
-	if err := conn.SetWriteDeadline(time.Now().Add(time.Second)); err != nil {
-		return nil, fmt.Errorf("failed to set write deadline: %w", err)
+	// Skip the 16-byte header
+	var resp discoveryResponse
+	if err := json.Unmarshal(data[16:], &resp); err != nil {
+		return DiscoveredDevice{}, false
 	}
 
-	_, err = conn.WriteToUDP(payload, broadcastAddr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send discovery broadcast: %w", err)
+	if resp.ErrorCode != 0 {
+		return DiscoveredDevice{}, false
 	}
 
-	deadline := time.Now().Add(timeout)
-	for {
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		default:
-		}
+	r := resp.Result
+	ip := r.IP
+	if ip == "" {
+		ip = addr.IP.String()
+	}
 
-		remaining := time.Until(deadline)
-		if remaining <= 0 {
-			break
-		}
+	return DiscoveredDevice{
+		IP:              ip,
+		MAC:             r.MAC,
+		DeviceID:        r.DeviceID,
+		Model:           r.DeviceModel,
+		Type:            r.DeviceType,
+		Owner:           r.Owner,
+		EncryptType:     r.MgtEncryptSchm.EncryptType,
+		HTTPPort:        r.MgtEncryptSchm.HTTPPort,
+		HTTPS:           r.MgtEncryptSchm.IsSupportHTTPS,
+		ProtocolVersion: r.MgtEncryptSchm.LV,
+		FactoryDefault:  r.FactoryDefault,
+	}, true
+}
 
-		if err := conn.SetReadDeadline(time.Now().Add(remaining)); err != nil {
-			return nil, fmt.Errorf("failed to set read deadline: %w", err)
-		}
+// parseKasaResponse decodes a legacy Kasa discovery reply received from addr.
+func parseKasaResponse(data []byte, addr *net.UDPAddr) (DiscoveredDevice, bool) {
+	var resp struct {
+		System struct {
+			Sysinfo iotSysinfo `json:"get_sysinfo"`
+		} `json:"system"`
+	}
+	if err := json.Unmarshal(kasaDecrypt(data), &resp); err != nil {
+		return DiscoveredDevice{}, false
+	}
 
-		buf := make([]byte, 2048)
-		n, addr, err := conn.ReadFromUDP(buf)
-		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				break
-			}
-			continue
-		}
+	info := resp.System.Sysinfo.deviceInfo()
+	if info.DeviceID == "" {
+		return DiscoveredDevice{}, false
+	}
+	return DiscoveredDevice{
+		IP:          addr.IP.String(),
+		MAC:         info.MAC,
+		DeviceID:    info.DeviceID,
+		Model:       info.Model,
+		Type:        info.Type,
+		Alias:       info.Nickname,
+		EncryptType: "XOR",
+	}, true
+}
 
-		if n <= 16 {
-			continue
-		}
+// PingKasa checks that a Kasa device answers the legacy get_sysinfo probe
+// at ip on UDP port 9999. It is Ping for devices found with Discoverer.Kasa
+// that don't speak KLAP.
+func PingKasa(ctx context.Context, ip string) error {
+	addr := &net.UDPAddr{IP: net.ParseIP(ip), Port: kasaDiscoveryPort}
+	if addr.IP == nil {
+		return fmt.Errorf("invalid IP %q", ip)
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create UDP socket: %w", err)
+	}
+	defer conn.Close()
 
-		jsonData := buf[16:n]
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(discoveryTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return fmt.Errorf("failed to set deadline: %w", err)
+	}
+	// Unblock the read if ctx is cancelled before its deadline.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
 
-		var resp discoveryResponse
-		if err := json.Unmarshal(jsonData, &resp); err != nil {
-			continue
+	if _, err := conn.WriteToUDP(kasaEncrypt([]byte(kasaSysinfo)), addr); err != nil {
+		return fmt.Errorf("failed to send probe to %s: %w", ip, err)
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return fmt.Errorf("no reply from %s: %w", ip, err)
 		}
-
-		if resp.ErrorCode != 0 {
-			continue
+		if _, ok := parseKasaResponse(buf[:n], from); ok && from.IP.Equal(addr.IP) {
+			return nil
 		}
+	}
+}
 
-		ip := resp.Result.IP
-		if ip == "" {
-			ip = addr.IP.String()
-		}
+// kasaEncrypt applies the Kasa autokey XOR cipher, where each byte is XORed
+// with the previous ciphertext byte, starting from 171.
+func kasaEncrypt(plaintext []byte) []byte {
+	key := byte(171)
+	out := make([]byte, len(plaintext))
+	for i, b := range plaintext {
+		key ^= b
+		out[i] = key
+	}
+	return out
+}
 
-		return &DiscoveredDevice{
-			IP:       ip,
-			MAC:      resp.Result.MAC,
-			DeviceID: resp.Result.DeviceID,
-			Model:    resp.Result.DeviceModel,
-		}, nil
+func kasaDecrypt(ciphertext []byte) []byte {
+	key := byte(171)
+	out := make([]byte, len(ciphertext))
+	for i, b := range ciphertext {
+		out[i] = key ^ b
+		key = b
 	}
+	return out
+}
+
+// Discover finds Tapo devices on the local network using UDP broadcast.
+// It returns a slice of discovered devices.
+func Discover(ctx context.Context) ([]DiscoveredDevice, error) {
+	return DiscoverWithTimeout(ctx, discoveryTimeout)
+}
+
+// DiscoverWithTimeout finds Tapo devices with a custom timeout.
+func DiscoverWithTimeout(ctx context.Context, timeout time.Duration) ([]DiscoveredDevice, error) {
+	return NewDiscoverer(timeout).All(ctx)
+}
 
-	return nil, fmt.Errorf("no Tapo devices found on the network")
+// DiscoverFirst finds the first Tapo device on the network.
+// Returns an error if no device is found within the timeout.
+func DiscoverFirst(ctx context.Context) (*DiscoveredDevice, error) {
+	return DiscoverFirstWithTimeout(ctx, discoveryTimeout)
+}
+
+// DiscoverFirstWithTimeout finds the first device with a custom timeout.
+func DiscoverFirstWithTimeout(ctx context.Context, timeout time.Duration) (*DiscoveredDevice, error) {
+	return NewDiscoverer(timeout).First(ctx)
 }
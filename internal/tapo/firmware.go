@@ -0,0 +1,47 @@
+package tapo
+
+// LatestFirmware is the newest firmware the cloud offers the device.
+type LatestFirmware struct {
+	Type          int    `json:"type"`
+	Version       string `json:"fw_ver"`
+	ReleaseDate   string `json:"release_date"`
+	ReleaseNote   string `json:"release_note"`
+	Size          int    `json:"fw_size"`
+	NeedToUpgrade bool   `json:"need_to_upgrade"`
+}
+
+// FirmwareDownloadState reports progress after DownloadFirmware. Once the
+// download finishes the device flashes the image and reboots, dropping the
+// connection for about RebootTime+UpgradeTime seconds.
+type FirmwareDownloadState struct {
+	Status           int  `json:"status"`
+	DownloadProgress int  `json:"download_progress"` // percent
+	RebootTime       int  `json:"reboot_time"`       // seconds
+	UpgradeTime      int  `json:"upgrade_time"`      // seconds
+	AutoUpgrade      bool `json:"auto_upgrade"`
+}
+
+// GetLatestFirmware asks the device which firmware is available. The device
+// queries the Tapo cloud, so this fails when it has no internet access.
+func (p *P110) GetLatestFirmware() (*LatestFirmware, error) {
+	var fw LatestFirmware
+	if err := p.call("get_latest_fw", nil, &fw); err != nil {
+		return nil, err
+	}
+	return &fw, nil
+}
+
+// DownloadFirmware starts downloading and installing the latest firmware.
+// The device reboots when it is done.
+func (p *P110) DownloadFirmware() error {
+	return p.call("fw_download", nil, nil)
+}
+
+// GetFirmwareDownloadState reports the progress of DownloadFirmware.
+func (p *P110) GetFirmwareDownloadState() (*FirmwareDownloadState, error) {
+	var state FirmwareDownloadState
+	if err := p.call("get_fw_download_state", nil, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
@@ -2,11 +2,14 @@ package tapo
 
 import (
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -25,6 +28,8 @@ type klapSession struct {
 	localSeed  []byte
 	remoteSeed []byte
 	authHash   []byte
+	authHashV1 []byte // for Kasa firmware, which speaks KLAP v1
+	legacy     bool   // the device answered with KLAP v1
 	key        []byte
 	ivSeq      []byte
 	sig        []byte
@@ -34,36 +39,57 @@ type klapSession struct {
 	host       string
 }
 
-// newKlapSession creates a new KLAP session for the given device IP.
-func newKlapSession(ip, username, password string) (*klapSession, error) {
+// newKlapSession creates a new KLAP session for the device at ip, served
+// on port (0 for the default) over HTTP or HTTPS. authHashV1 may be nil.
+func newKlapSession(ip string, port int, https bool, authHash, authHashV1 []byte) (*klapSession, error) {
 	jar, err := cookiejar.New(nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cookie jar: %w", err)
 	}
 
-	client := &http.Client{
-		Jar:     jar,
-		Timeout: 10 * time.Second,
-	}
+	client := newHTTPClient(https)
+	client.Jar = jar
 
 	localSeed := make([]byte, klapSeedSize)
 	if _, err := rand.Read(localSeed); err != nil {
 		return nil, fmt.Errorf("failed to generate local seed: %w", err)
 	}
 
-	authHash := generateAuthHash(username, password)
-
 	session := &klapSession{
 		localSeed:  localSeed,
 		authHash:   authHash,
+		authHashV1: authHashV1,
 		httpClient: client,
-		baseURL:    fmt.Sprintf("http://%s/app", ip),
+		baseURL:    appURL(ip, port, https),
 		host:       ip,
 	}
 
 	return session, nil
 }
 
+// appURL returns the KLAP base URL for a device, leaving out default ports.
+func appURL(ip string, port int, https bool) string {
+	scheme, defaultPort := "http", 80
+	if https {
+		scheme, defaultPort = "https", 443
+	}
+	if port == 0 || port == defaultPort {
+		return fmt.Sprintf("%s://%s/app", scheme, ip)
+	}
+	return fmt.Sprintf("%s://%s:%d/app", scheme, ip, port)
+}
+
+// newHTTPClient returns the HTTP client for a device. Devices serving HTTPS
+// use self-signed certificates, so they aren't verified; KLAP authenticates
+// the device itself.
+func newHTTPClient(https bool) *http.Client {
+	client := &http.Client{Timeout: 10 * time.Second}
+	if https {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	return client
+}
+
 // generateAuthHash creates the authentication hash from credentials.
 // authHash = SHA256(SHA1(username) + SHA1(password))
 func generateAuthHash(username, password string) []byte {
@@ -78,6 +104,16 @@ func generateAuthHash(username, password string) []byte {
 	return authHash[:]
 }
 
+// generateAuthHashV1 creates the KLAP v1 authentication hash used by Kasa
+// firmware. authHash = MD5(MD5(username) + MD5(password))
+func generateAuthHashV1(username, password string) []byte {
+	userHash := md5.Sum([]byte(username))
+	passHash := md5.Sum([]byte(password))
+
+	authHash := md5.Sum(concat(userHash[:], passHash[:]))
+	return authHash[:]
+}
+
 // handshake performs the KLAP handshake to establish encryption keys.
 func (s *klapSession) handshake() error {
 	// Handshake 1: Send local seed, receive remote seed
@@ -96,6 +132,40 @@ func (s *klapSession) handshake() error {
 	return nil
 }
 
+// Ping checks that a KLAP device answers at ip, on port (0 for the default)
+// over HTTP or HTTPS, by sending the first handshake message, which needs no
+// credentials. It confirms a remembered address far quicker than discovery.
+func Ping(ctx context.Context, ip string, port int, https bool) error {
+	seed := make([]byte, klapSeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return fmt.Errorf("failed to generate local seed: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, appURL(ip, port, https)+"/handshake1", bytes.NewReader(seed))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := newHTTPClient(https).Do(req)
+	if err != nil {
+		return fmt.Errorf("POST request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("handshake1 returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	if len(body) != 48 {
+		return fmt.Errorf("unexpected handshake1 response length: %d", len(body))
+	}
+	return nil
+}
+
 // handshake1 performs the first step of the KLAP handshake.
 func (s *klapSession) handshake1() error {
 	reqURL := s.baseURL + "/handshake1"
@@ -123,9 +193,14 @@ func (s *klapSession) handshake1() error {
 	s.remoteSeed = body[:16]
 	serverHash := body[16:48]
 
-	// Verify server hash: SHA256(local_seed + remote_seed + auth_hash)
-	expectedHash := s.calculateServerHash()
-	if !bytes.Equal(serverHash, expectedHash) {
+	// Verify server hash: SHA256(local_seed + remote_seed + auth_hash), or
+	// for KLAP v1, SHA256(local_seed + auth_hash) with the v1 hash.
+	switch {
+	case bytes.Equal(serverHash, s.calculateServerHash()):
+	case s.authHashV1 != nil && bytes.Equal(serverHash, sha256Hash(s.localSeed, s.authHashV1)):
+		s.legacy = true
+		s.authHash = s.authHashV1
+	default:
 		return fmt.Errorf("server hash verification failed (invalid credentials?)")
 	}
 
@@ -161,8 +236,12 @@ func (s *klapSession) calculateServerHash() []byte {
 	return h.Sum(nil)
 }
 
-// calculateClientHash calculates the client hash for handshake2.
+// calculateClientHash calculates the client hash for handshake2. KLAP v1
+// leaves out the local seed.
 func (s *klapSession) calculateClientHash() []byte {
+	if s.legacy {
+		return sha256Hash(s.remoteSeed, s.authHash)
+	}
 	h := sha256.New()
 	h.Write(s.remoteSeed)
 	h.Write(s.localSeed)
@@ -302,7 +381,7 @@ func (s *klapSession) decrypt(payload []byte, seq int32) ([]byte, error) {
 }
 
 // request sends an encrypted request and decrypts the response.
-func (s *klapSession) request(payload []byte) ([]byte, error) {
+func (s *klapSession) request(ctx context.Context, payload []byte) ([]byte, error) {
 	encrypted, seq, err := s.encrypt(payload)
 	if err != nil {
 		return nil, fmt.Errorf("encryption failed: %w", err)
@@ -310,7 +389,13 @@ func (s *klapSession) request(payload []byte) ([]byte, error) {
 
 	reqURL := fmt.Sprintf("%s/request?seq=%d", s.baseURL, seq)
 
-	resp, err := s.httpClient.Post(reqURL, "application/octet-stream", bytes.NewReader(encrypted))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(encrypted))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := s.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("POST request failed: %w", err)
 	}
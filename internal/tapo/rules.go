@@ -0,0 +1,217 @@
+package tapo
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// On-device rules run on the plug itself, so they keep working when nothing
+// on the network is polling it. Rule IDs are assigned by the device ("C1",
+// "S3", "A2", ...) and are needed to edit or remove a rule.
+
+// DesiredStates is the state a rule switches the plug to.
+type DesiredStates struct {
+	On bool `json:"on"`
+}
+
+// Schedule start/end types.
+const (
+	TimeNormal  = "normal"  // minutes after local midnight
+	TimeSunrise = "sunrise" // offset from sunrise at the device's location
+	TimeSunset  = "sunset"  // offset from sunset at the device's location
+	TimeNone    = "none"    // no end time
+)
+
+// Schedule repeat modes.
+const (
+	ModeRepeat = "repeat" // on the days in WeekDays
+	ModeOnce   = "once"   // on Day/Month/Year only
+)
+
+// CountdownRule switches the plug after a delay.
+type CountdownRule struct {
+	ID            string        `json:"id,omitempty"`
+	Enable        bool          `json:"enable"`
+	Delay         int           `json:"delay"`            // seconds
+	Remain        int           `json:"remain,omitempty"` // seconds left while running
+	DesiredStates DesiredStates `json:"desired_states"`
+}
+
+// RuleTime is when a schedule or away-mode rule runs.
+type RuleTime struct {
+	Mode       string `json:"mode"`     // ModeRepeat or ModeOnce
+	WeekDays   int    `json:"week_day"` // bitmask, bit 0 is Sunday
+	Day        int    `json:"day,omitempty"`
+	Month      int    `json:"month,omitempty"`
+	Year       int    `json:"year,omitempty"`
+	StartType  string `json:"s_type"`      // TimeNormal, TimeSunrise or TimeSunset
+	StartMin   int    `json:"s_min"`       // minutes after midnight for TimeNormal
+	TimeOffset int    `json:"time_offset"` // minutes from sunrise/sunset, may be negative
+	EndType    string `json:"e_type"`
+	EndMin     int    `json:"e_min"`
+}
+
+// ScheduleRule switches the plug at a time of day, either a fixed time or an
+// offset from sunrise or sunset.
+type ScheduleRule struct {
+	ID     string `json:"id,omitempty"`
+	Enable bool   `json:"enable"`
+	RuleTime
+	EndAction     string        `json:"e_action"`
+	DesiredStates DesiredStates `json:"desired_states"`
+}
+
+// AwayRule switches the plug on and off at random between its start and end
+// times, so an empty home looks occupied.
+type AwayRule struct {
+	ID     string `json:"id,omitempty"`
+	Enable bool   `json:"enable"`
+	RuleTime
+	Frequency int `json:"frequency,omitempty"` // switches per hour
+}
+
+// RuleList is the set of rules of one kind on a device.
+type RuleList[T any] struct {
+	Enabled  bool // whether this kind of rule is enabled at all
+	MaxCount int  // how many rules the device can hold
+	Rules    []T
+}
+
+// ruleKind names the API methods and response fields for one kind of rule.
+type ruleKind struct {
+	name     string // method suffix, e.g. "countdown_rule"
+	maxField string
+}
+
+var (
+	countdownRules = ruleKind{"countdown_rule", "countdown_rule_max_count"}
+	scheduleRules  = ruleKind{"schedule_rule", "schedule_rule_max_count"}
+	awayRules      = ruleKind{"antitheft_rule", "antitheft_rule_max_count"}
+)
+
+// getRules fetches every rule of a kind, following the device's pagination.
+func getRules[T any](p *P110, kind ruleKind) (*RuleList[T], error) {
+	list := &RuleList[T]{}
+	for {
+		result, err := p.sendRequest("get_"+kind.name+"s", map[string]int{"start_index": len(list.Rules)})
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Enable   bool `json:"enable"`
+			Sum      int  `json:"sum"`
+			RuleList []T  `json:"rule_list"`
+		}
+		if err := json.Unmarshal(result, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse %ss: %w", kind.name, err)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(result, &fields); err == nil {
+			json.Unmarshal(fields[kind.maxField], &list.MaxCount)
+		}
+
+		list.Enabled = page.Enable
+		list.Rules = append(list.Rules, page.RuleList...)
+		if len(page.RuleList) == 0 || len(list.Rules) >= page.Sum {
+			return list, nil
+		}
+	}
+}
+
+// addRule creates a rule and returns the ID the device assigned.
+func (p *P110) addRule(kind ruleKind, rule interface{}) (string, error) {
+	result, err := p.sendRequest("add_"+kind.name, rule)
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(result, &resp); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", kind.name, err)
+	}
+	return resp.ID, nil
+}
+
+// editRule replaces the rule with the same ID.
+func (p *P110) editRule(kind ruleKind, id string, rule interface{}) error {
+	if id == "" {
+		return fmt.Errorf("%s has no id", kind.name)
+	}
+	_, err := p.sendRequest("edit_"+kind.name, rule)
+	return err
+}
+
+// removeRule deletes the rule with the given ID.
+func (p *P110) removeRule(kind ruleKind, id string) error {
+	_, err := p.sendRequest("remove_"+kind.name, map[string]string{"id": id})
+	return err
+}
+
+// GetCountdownRules lists the device's countdown timers.
+func (p *P110) GetCountdownRules() (*RuleList[CountdownRule], error) {
+	return getRules[CountdownRule](p, countdownRules)
+}
+
+// AddCountdownRule starts a countdown and returns its ID.
+func (p *P110) AddCountdownRule(rule CountdownRule) (string, error) {
+	if rule.Remain == 0 {
+		rule.Remain = rule.Delay
+	}
+	return p.addRule(countdownRules, rule)
+}
+
+// EditCountdownRule replaces the countdown with rule.ID.
+func (p *P110) EditCountdownRule(rule CountdownRule) error {
+	if rule.Remain == 0 {
+		rule.Remain = rule.Delay
+	}
+	return p.editRule(countdownRules, rule.ID, rule)
+}
+
+// RemoveCountdownRule deletes a countdown.
+func (p *P110) RemoveCountdownRule(id string) error {
+	return p.removeRule(countdownRules, id)
+}
+
+// GetScheduleRules lists the device's schedules.
+func (p *P110) GetScheduleRules() (*RuleList[ScheduleRule], error) {
+	return getRules[ScheduleRule](p, scheduleRules)
+}
+
+// AddScheduleRule creates a schedule and returns its ID.
+func (p *P110) AddScheduleRule(rule ScheduleRule) (string, error) {
+	return p.addRule(scheduleRules, rule)
+}
+
+// EditScheduleRule replaces the schedule with rule.ID.
+func (p *P110) EditScheduleRule(rule ScheduleRule) error {
+	return p.editRule(scheduleRules, rule.ID, rule)
+}
+
+// RemoveScheduleRule deletes a schedule.
+func (p *P110) RemoveScheduleRule(id string) error {
+	return p.removeRule(scheduleRules, id)
+}
+
+// GetAwayRules lists the device's away-mode rules.
+func (p *P110) GetAwayRules() (*RuleList[AwayRule], error) {
+	return getRules[AwayRule](p, awayRules)
+}
+
+// AddAwayRule creates an away-mode rule and returns its ID.
+func (p *P110) AddAwayRule(rule AwayRule) (string, error) {
+	return p.addRule(awayRules, rule)
+}
+
+// EditAwayRule replaces the away-mode rule with rule.ID.
+func (p *P110) EditAwayRule(rule AwayRule) error {
+	return p.editRule(awayRules, rule.ID, rule)
+}
+
+// RemoveAwayRule deletes an away-mode rule.
+func (p *P110) RemoveAwayRule(id string) error {
+	return p.removeRule(awayRules, id)
+}
@@ -0,0 +1,133 @@
+package tapo
+
+import "context"
+
+// LED rules.
+const (
+	LEDAlways = "always"
+	LEDNever  = "never"
+	LEDNight  = "auto" // off during the night mode window
+)
+
+// Default state types.
+const (
+	DefaultLastState = "last_states" // restore the state from before the outage
+	DefaultCustom    = "custom"      // always come up in State
+)
+
+// LEDInfo is the front LED configuration.
+type LEDInfo struct {
+	Enable    bool       `json:"led_enable"`
+	Status    bool       `json:"led_status"` // whether the LED is lit right now
+	Rule      string     `json:"led_rule"`   // LEDAlways, LEDNever or LEDNight
+	NightMode *NightMode `json:"night_mode,omitempty"`
+}
+
+// NightMode is the window in which an LEDNight LED stays off.
+type NightMode struct {
+	Type          string `json:"night_mode_type"` // "sunrise_sunset" or "custom"
+	SunriseOffset int    `json:"sunrise_offset"`  // minutes
+	SunsetOffset  int    `json:"sunset_offset"`   // minutes
+	StartTime     int    `json:"start_time"`      // minutes after midnight, for "custom"
+	EndTime       int    `json:"end_time"`        // minutes after midnight, for "custom"
+}
+
+// DefaultState is the state the plug takes when power returns.
+type DefaultState struct {
+	Type  string        `json:"type"` // DefaultLastState or DefaultCustom
+	State DesiredStates `json:"state"`
+}
+
+// PowerProtection is the overload protection threshold. When the load
+// exceeds Watts the plug switches off and reports power_protection_status.
+type PowerProtection struct {
+	Enabled bool `json:"enabled"`
+	Watts   int  `json:"protection_power"`
+}
+
+// AutoOff switches the plug off a fixed time after it is turned on.
+type AutoOff struct {
+	Enable   bool `json:"enable"`
+	DelayMin int  `json:"delay_min"`
+}
+
+// call is Call without a context.
+func (p *P110) call(method string, params, out interface{}) error {
+	return p.Call(context.Background(), method, params, out)
+}
+
+// GetLEDInfo retrieves the LED configuration.
+func (p *P110) GetLEDInfo() (*LEDInfo, error) {
+	var info LEDInfo
+	if err := p.call("get_led_info", nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// SetLEDInfo updates the LED configuration. The device expects the full
+// structure, so start from GetLEDInfo.
+func (p *P110) SetLEDInfo(info LEDInfo) error {
+	info.Enable = info.Rule != LEDNever
+	return p.call("set_led_info", info, nil)
+}
+
+// GetDefaultState retrieves the state the plug takes after a power outage.
+func (p *P110) GetDefaultState() (*DefaultState, error) {
+	var resp struct {
+		DefaultStates DefaultState `json:"default_states"`
+	}
+	if err := p.call("get_device_info", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.DefaultStates, nil
+}
+
+// SetDefaultState sets the state the plug takes after a power outage.
+func (p *P110) SetDefaultState(state DefaultState) error {
+	return p.call("set_device_info", map[string]interface{}{"default_states": state}, nil)
+}
+
+// GetPowerProtection retrieves the overload protection threshold.
+func (p *P110) GetPowerProtection() (*PowerProtection, error) {
+	var pp PowerProtection
+	if err := p.call("get_protection_power", nil, &pp); err != nil {
+		return nil, err
+	}
+	return &pp, nil
+}
+
+// SetPowerProtection sets the overload protection threshold.
+func (p *P110) SetPowerProtection(pp PowerProtection) error {
+	return p.call("set_protection_power", pp, nil)
+}
+
+// GetAutoOff retrieves the auto-off timer.
+func (p *P110) GetAutoOff() (*AutoOff, error) {
+	var ao AutoOff
+	if err := p.call("get_auto_off_config", nil, &ao); err != nil {
+		return nil, err
+	}
+	return &ao, nil
+}
+
+// SetAutoOff sets the auto-off timer.
+func (p *P110) SetAutoOff(ao AutoOff) error {
+	return p.call("set_auto_off_config", ao, nil)
+}
+
+// GetChildLock reports whether the physical button is locked.
+func (p *P110) GetChildLock() (bool, error) {
+	var resp struct {
+		ChildProtection bool `json:"child_protection"`
+	}
+	if err := p.call("get_child_protection", nil, &resp); err != nil {
+		return false, err
+	}
+	return resp.ChildProtection, nil
+}
+
+// SetChildLock locks or unlocks the physical button.
+func (p *P110) SetChildLock(locked bool) error {
+	return p.call("set_child_protection", map[string]bool{"enable": locked}, nil)
+}
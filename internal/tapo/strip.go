@@ -0,0 +1,220 @@
+package tapo
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stripModels are the models that host their outlets as child devices.
+var stripModels = []string{"P300", "P304", "P306", "P316"}
+
+// IsStrip reports whether a model is a multi-outlet power strip.
+func IsStrip(model string) bool {
+	for _, m := range stripModels {
+		if strings.HasPrefix(model, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// ChildKey is the device key a strip outlet is stored under: the strip's IP
+// and the outlet's position, e.g. "192.168.1.50/2".
+func ChildKey(ip string, position int) string {
+	return ip + "/" + strconv.Itoa(position)
+}
+
+// ChildInfo describes one outlet of a power strip.
+type ChildInfo struct {
+	DeviceID        string `json:"device_id"`
+	Nickname        string `json:"nickname"`
+	Model           string `json:"model"`
+	Type            string `json:"type"`
+	Position        int    `json:"position"`
+	SlotNumber      int    `json:"slot_number"`
+	FirmwareVersion string `json:"fw_ver"`
+	DeviceON        bool   `json:"device_on"`
+	OnTime          int    `json:"on_time"`
+	OverHeated      bool   `json:"overheated"`
+}
+
+// Strip is a connection to a power strip such as the P300 or P304M. The
+// strip itself answers like a plug; its outlets are reached through Child.
+type Strip struct {
+	*P110
+}
+
+// ConnectStrip establishes a connection to a power strip.
+func (c *Client) ConnectStrip(ip string) (*Strip, error) {
+	p, err := c.Connect(ip)
+	if err != nil {
+		return nil, err
+	}
+	return &Strip{P110: p}, nil
+}
+
+// Strip returns the connection as a power strip.
+func (p *P110) Strip() *Strip {
+	return &Strip{P110: p}
+}
+
+// Children lists the strip's outlets.
+func (s *Strip) Children() ([]ChildInfo, error) {
+	var children []ChildInfo
+	for {
+		result, err := s.sendRequest("get_child_device_list", map[string]int{"start_index": len(children)})
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			ChildDeviceList []ChildInfo `json:"child_device_list"`
+			Sum             int         `json:"sum"`
+		}
+		if err := json.Unmarshal(result, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse child device list: %w", err)
+		}
+
+		for _, c := range page.ChildDeviceList {
+			c.Nickname = decodeBase64(c.Nickname)
+			children = append(children, c)
+		}
+		if len(page.ChildDeviceList) == 0 || len(children) >= page.Sum {
+			return children, nil
+		}
+	}
+}
+
+// Child returns a handle for the outlet with the given device ID.
+func (s *Strip) Child(deviceID string) *Child {
+	return &Child{strip: s, deviceID: deviceID}
+}
+
+// Child is one outlet of a power strip. It offers the same switching and
+// energy methods as a plug; outlets without metering return an error from
+// the energy methods.
+type Child struct {
+	strip    *Strip
+	deviceID string
+}
+
+// DeviceID returns the outlet's device ID.
+func (c *Child) DeviceID() string {
+	return c.deviceID
+}
+
+// sendRequest wraps a request in control_child and unwraps its response.
+func (c *Child) sendRequest(method string, params interface{}) (json.RawMessage, error) {
+	inner := map[string]interface{}{"method": method}
+	if params != nil {
+		inner["params"] = params
+	}
+
+	result, err := c.strip.sendRequest("control_child", map[string]interface{}{
+		"device_id": c.deviceID,
+		"requestData": map[string]interface{}{
+			"method": "multipleRequest",
+			"params": map[string]interface{}{
+				"requests": []interface{}{inner},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		ResponseData struct {
+			Result struct {
+				Responses []struct {
+					Method    string          `json:"method"`
+					Result    json.RawMessage `json:"result"`
+					ErrorCode int             `json:"error_code"`
+				} `json:"responses"`
+			} `json:"result"`
+		} `json:"responseData"`
+	}
+	if err := json.Unmarshal(result, &resp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal child response: %w", err)
+	}
+
+	responses := resp.ResponseData.Result.Responses
+	if len(responses) == 0 {
+		return nil, fmt.Errorf("empty child response for %s", method)
+	}
+	if responses[0].ErrorCode != 0 {
+		return nil, &DeviceError{Code: responses[0].ErrorCode}
+	}
+	return responses[0].Result, nil
+}
+
+// call sends a request to the outlet and decodes its result into out, if
+// out is non-nil.
+func (c *Child) call(method string, params, out interface{}) error {
+	result, err := c.sendRequest(method, params)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(result, out); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", method, err)
+	}
+	return nil
+}
+
+// GetDeviceInfo retrieves the outlet's information.
+func (c *Child) GetDeviceInfo() (*ChildInfo, error) {
+	var info ChildInfo
+	if err := c.call("get_device_info", nil, &info); err != nil {
+		return nil, err
+	}
+	info.Nickname = decodeBase64(info.Nickname)
+	return &info, nil
+}
+
+// GetCurrentPower retrieves the outlet's current power consumption.
+func (c *Child) GetCurrentPower() (*CurrentPower, error) {
+	var power CurrentPower
+	if err := c.call("get_current_power", nil, &power); err != nil {
+		return nil, err
+	}
+	return &power, nil
+}
+
+// GetEnergyUsage retrieves the outlet's energy usage.
+func (c *Child) GetEnergyUsage() (*EnergyUsage, error) {
+	var usage EnergyUsage
+	if err := c.call("get_energy_usage", nil, &usage); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// GetEnergyData retrieves the outlet's energy data for the specified interval.
+func (c *Child) GetEnergyData(interval EnergyDataInterval, t time.Time) (*EnergyData, error) {
+	params, err := newEnergyDataParams(interval, t)
+	if err != nil {
+		return nil, err
+	}
+
+	var data EnergyData
+	if err := c.call("get_energy_data", params, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// TurnOn turns the outlet on.
+func (c *Child) TurnOn() error {
+	return c.call("set_device_info", map[string]bool{"device_on": true}, nil)
+}
+
+// TurnOff turns the outlet off.
+func (c *Child) TurnOff() error {
+	return c.call("set_device_info", map[string]bool{"device_on": false}, nil)
+}
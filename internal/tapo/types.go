@@ -34,6 +34,12 @@ type DeviceInfo struct {
 	OverHeated            bool   `json:"overheated"`
 	PowerProtectionStatus string `json:"power_protection_status"`
 	Location              string `json:"location"`
+
+	// Bulbs only
+	Brightness int `json:"brightness,omitempty"`
+	Hue        int `json:"hue,omitempty"`
+	Saturation int `json:"saturation,omitempty"`
+	ColorTemp  int `json:"color_temp,omitempty"`
 }
 
 // DeviceUsage contains usage statistics for a Tapo device.
@@ -90,7 +96,17 @@ type DiscoveredDevice struct {
 	MAC      string
 	DeviceID string
 	Model    string
+	Type     string // e.g. SMART.TAPOPLUG or SMART.TAPOBULB
 	Alias    string
+
+	Owner           string // hash of the owning Tapo account
+	EncryptType     string // e.g. KLAP or AES
+	HTTPPort        int
+	HTTPS           bool
+	ProtocolVersion int // mgt_encrypt_schm.lv
+	FactoryDefault  bool
+	Interface       string // local interface the reply arrived on, if known
+	Answers         int    // replies received to retransmitted probes; fewer suggests a lossy link
 }
 
 // discoveryResponse is the raw response from UDP discovery.
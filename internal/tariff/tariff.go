@@ -0,0 +1,326 @@
+package tariff
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// Tariff is one version of an electricity tariff, effective from a given date
+// until the next version takes over.
+//
+// Energy in an hour covered by a Period is charged at that period's rate.
+// Other energy is charged by monthly slab Tiers if any are defined, or at the
+// flat Rate otherwise. All consumption counts toward the monthly slabs.
+type Tariff struct {
+	EffectiveFrom string   `json:"effective_from"` // YYYY-MM-DD
+	Rate          float64  `json:"rate"`           // per kWh
+	Tiers         []Tier   `json:"tiers,omitempty"`
+	Periods       []Period `json:"periods,omitempty"`
+	DailyCharge   float64  `json:"daily_charge,omitempty"` // fixed charge per day
+	TaxPercent    float64  `json:"tax_percent,omitempty"`  // applied to energy and fixed charges
+
+	effective time.Time
+	days      []map[time.Weekday]bool
+}
+
+// Tier is a monthly consumption slab.
+type Tier struct {
+	UpToKWh float64 `json:"up_to_kwh,omitempty"` // cumulative monthly limit; 0 means unlimited
+	Rate    float64 `json:"rate"`
+}
+
+// Period is a time-of-use window, such as peak or off-peak hours.
+type Period struct {
+	Name      string   `json:"name"`
+	Days      []string `json:"days,omitempty"` // mon..sun, "weekday" or "weekend"; empty means every day
+	StartHour int      `json:"start_hour"`     // 0-23
+	EndHour   int      `json:"end_hour"`       // 1-24, exclusive; may be less than StartHour to wrap midnight
+	Rate      float64  `json:"rate"`
+}
+
+// Schedule is a validated set of effective-dated tariff versions.
+type Schedule struct {
+	versions []Tariff
+}
+
+// Usage is energy consumed between Start and End. Energy is assumed to be
+// spread evenly over the span, so hourly records are priced exactly and daily
+// or monthly totals are approximated hour by hour.
+type Usage struct {
+	Start    time.Time
+	End      time.Time
+	EnergyWh float64
+}
+
+// Breakdown is the result of pricing a set of usage records.
+type Breakdown struct {
+	KWh     float64
+	Energy  float64 // energy charges before tax
+	Fixed   float64 // daily charges before tax
+	Tax     float64
+	Total   float64
+	ByDay   map[string]float64 // total keyed by YYYY-MM-DD
+	ByMonth map[string]float64 // total keyed by YYYY-MM
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// NewSchedule validates and orders tariff versions.
+func NewSchedule(versions []Tariff) (*Schedule, error) {
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no tariff versions defined")
+	}
+
+	s := &Schedule{versions: make([]Tariff, len(versions))}
+	copy(s.versions, versions)
+
+	for i := range s.versions {
+		v := &s.versions[i]
+		if v.EffectiveFrom != "" {
+			t, err := time.ParseInLocation(dateLayout, v.EffectiveFrom, time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("tariff %d: invalid effective_from %q", i+1, v.EffectiveFrom)
+			}
+			v.effective = t
+		}
+
+		for j, tier := range v.Tiers {
+			if tier.UpToKWh == 0 && j != len(v.Tiers)-1 {
+				return nil, fmt.Errorf("tariff %d: only the last tier may be unlimited", i+1)
+			}
+			if j > 0 && tier.UpToKWh != 0 && tier.UpToKWh <= v.Tiers[j-1].UpToKWh {
+				return nil, fmt.Errorf("tariff %d: tier limits must increase", i+1)
+			}
+		}
+
+		v.days = make([]map[time.Weekday]bool, len(v.Periods))
+		for j, p := range v.Periods {
+			if p.StartHour < 0 || p.StartHour > 23 || p.EndHour < 1 || p.EndHour > 24 || p.StartHour == p.EndHour {
+				return nil, fmt.Errorf("tariff %d: period %q has invalid hours %d-%d", i+1, p.Name, p.StartHour, p.EndHour)
+			}
+			days, err := parseDays(p.Days)
+			if err != nil {
+				return nil, fmt.Errorf("tariff %d: period %q: %w", i+1, p.Name, err)
+			}
+			v.days[j] = days
+		}
+	}
+
+	sort.SliceStable(s.versions, func(i, j int) bool {
+		return s.versions[i].effective.Before(s.versions[j].effective)
+	})
+
+	return s, nil
+}
+
+// Flat returns a schedule with a single flat rate per kWh.
+func Flat(rate float64) *Schedule {
+	return &Schedule{versions: []Tariff{{Rate: rate}}}
+}
+
+// parseDays converts day names into a weekday set. A nil set means every day.
+func parseDays(names []string) (map[time.Weekday]bool, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	days := make(map[time.Weekday]bool)
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "weekday", "weekdays":
+			for d := time.Monday; d <= time.Friday; d++ {
+				days[d] = true
+			}
+		case "weekend", "weekends":
+			days[time.Saturday] = true
+			days[time.Sunday] = true
+		default:
+			if len(name) > 3 {
+				name = name[:3]
+			}
+			d, ok := weekdays[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown day %q", name)
+			}
+			days[d] = true
+		}
+	}
+	return days, nil
+}
+
+// at returns the tariff version in effect at t.
+func (s *Schedule) at(t time.Time) *Tariff {
+	v := &s.versions[0]
+	for i := range s.versions {
+		if s.versions[i].effective.After(t) {
+			break
+		}
+		v = &s.versions[i]
+	}
+	return v
+}
+
+// period returns the time-of-use period covering the hour starting at t, if any.
+func (v *Tariff) period(t time.Time) *Period {
+	hour := t.Hour()
+	for i := range v.Periods {
+		p := &v.Periods[i]
+		if v.days[i] != nil && !v.days[i][t.Weekday()] {
+			continue
+		}
+		if p.StartHour < p.EndHour {
+			if hour >= p.StartHour && hour < p.EndHour {
+				return p
+			}
+		} else if hour >= p.StartHour || hour < p.EndHour {
+			return p
+		}
+	}
+	return nil
+}
+
+// tieredCost prices kWh consumed after monthKWh has already been used this month.
+func (v *Tariff) tieredCost(monthKWh, kwh float64) float64 {
+	cost := 0.0
+	used := monthKWh
+	for _, tier := range v.Tiers {
+		if kwh <= 0 {
+			break
+		}
+		if tier.UpToKWh != 0 && used >= tier.UpToKWh {
+			continue
+		}
+		portion := kwh
+		if tier.UpToKWh != 0 && used+portion > tier.UpToKWh {
+			portion = tier.UpToKWh - used
+		}
+		cost += portion * tier.Rate
+		used += portion
+		kwh -= portion
+	}
+	// Anything beyond a bounded last tier is charged at that tier's rate.
+	if kwh > 0 {
+		cost += kwh * v.Tiers[len(v.Tiers)-1].Rate
+	}
+	return cost
+}
+
+// hourSlice is the share of a usage record that falls in one clock hour.
+type hourSlice struct {
+	start time.Time
+	wh    float64
+}
+
+// slices splits usage records into clock-hour slices in time order.
+func slices(usage []Usage) []hourSlice {
+	var out []hourSlice
+	for _, u := range usage {
+		if !u.End.After(u.Start) {
+			continue
+		}
+		total := u.End.Sub(u.Start).Seconds()
+		for t := u.Start; t.Before(u.End); {
+			// Truncate works in absolute time, which is wrong for zones with
+			// non-hour offsets, so step to the next local clock hour instead.
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			if next.After(u.End) {
+				next = u.End
+			}
+			share := next.Sub(t).Seconds() / total
+			out = append(out, hourSlice{start: t, wh: u.EnergyWh * share})
+			t = next
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].start.Before(out[j].start) })
+	return out
+}
+
+// Cost prices the usage records. Monthly slabs accumulate in time order, so
+// records should start at the beginning of a month for tiers to be exact.
+func (s *Schedule) Cost(usage []Usage) Breakdown {
+	b := Breakdown{
+		ByDay:   make(map[string]float64),
+		ByMonth: make(map[string]float64),
+	}
+
+	monthKWh := make(map[string]float64)
+	charged := make(map[string]bool)
+
+	for _, sl := range slices(usage) {
+		v := s.at(sl.start)
+		day := sl.start.Format(dateLayout)
+		month := sl.start.Format("2006-01")
+		kwh := sl.wh / 1000.0
+
+		var energy float64
+		if p := v.period(sl.start); p != nil {
+			energy = kwh * p.Rate
+		} else if len(v.Tiers) > 0 {
+			energy = v.tieredCost(monthKWh[month], kwh)
+		} else {
+			energy = kwh * v.Rate
+		}
+		monthKWh[month] += kwh
+
+		var fixed float64
+		if !charged[day] {
+			charged[day] = true
+			fixed = v.DailyCharge
+		}
+
+		tax := (energy + fixed) * v.TaxPercent / 100
+		total := energy + fixed + tax
+
+		b.KWh += kwh
+		b.Energy += energy
+		b.Fixed += fixed
+		b.Tax += tax
+		b.Total += total
+		b.ByDay[day] += total
+		b.ByMonth[month] += total
+	}
+
+	return b
+}
+
+// Hourly converts a day's hourly Wh values, as returned by the device or the
+// hourly table, into usage records.
+func Hourly(day time.Time, data []int) []Usage {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	usage := make([]Usage, 0, len(data))
+	for hour, wh := range data {
+		t := start.Add(time.Duration(hour) * time.Hour)
+		usage = append(usage, Usage{Start: t, End: t.Add(time.Hour), EnergyWh: float64(wh)})
+	}
+	return usage
+}
+
+// Day returns a usage record spreading a daily total over the day. For today,
+// pass the current time as end so the energy is spread over elapsed hours only.
+func Day(day time.Time, energyWh int, end time.Time) Usage {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	dayEnd := start.AddDate(0, 0, 1)
+	if end.IsZero() || end.After(dayEnd) || !end.After(start) {
+		end = dayEnd
+	}
+	return Usage{Start: start, End: end, EnergyWh: float64(energyWh)}
+}
+
+// Month returns a usage record spreading a monthly total over the month.
+func Month(year int, month time.Month, energyWh int, loc *time.Location) Usage {
+	start := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	return Usage{Start: start, End: start.AddDate(0, 1, 0), EnergyWh: float64(energyWh)}
+}